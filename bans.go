@@ -0,0 +1,61 @@
+package speedbump
+
+import (
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// Reset clears the counter of an id for the current period, so that it can
+// make max attempts again.
+func (r *RateLimiter) Reset(id string) error {
+	var err error
+	if r.serverTime {
+		_, err = r.runServerTime(r.current(), id, "reset")
+	} else {
+		err = r.redisClient.Del(r.hash(id)).Err()
+	}
+
+	// The cache is cleared after the counter, so that concurrent attempts
+	// can't cache the old counter again.
+	r.denied.remove(id)
+
+	return err
+}
+
+// Ban denies every attempt for an id during the provided duration, regardless
+// of its counter. Banned attempts are not counted.
+func (r *RateLimiter) Ban(id string, duration time.Duration) error {
+	return r.redisClient.Set(r.banKey(id), "1", duration).Err()
+}
+
+// Unban lifts the ban of an id, if there is one.
+func (r *RateLimiter) Unban(id string) error {
+	return r.redisClient.Del(r.banKey(id)).Err()
+}
+
+// Banned returns how long an id will remain banned for, or zero if it is not
+// banned.
+func (r *RateLimiter) Banned(id string) (time.Duration, error) {
+	ttl, err := r.redisClient.PTTL(r.banKey(id)).Result()
+	if err != nil && err != redis.Nil {
+		return 0, err
+	}
+
+	// PTTL returns a negative value when the key does not exist.
+	// See: http://redis.io/commands/PTTL
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// banKey generates the key that marks an id as banned.
+func (r *RateLimiter) banKey(id string) string {
+	if r.namespace == "" {
+		return "ban:" + id
+	}
+
+	return r.namespace + ":ban:" + id
+}
@@ -0,0 +1,64 @@
+package speedbump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReset(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 requests/min.
+	limiter := NewLimiter(client, PerMinuteHasher{}, 2)
+
+	makeNAttempts(t, limiter, "test_id", 2)
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	require.NoError(t, limiter.Reset("test_id"))
+
+	left, err := limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), left)
+}
+
+func TestBan(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 requests/min.
+	limiter := NewLimiter(client, PerMinuteHasher{}, 2, WithNamespace("login"))
+
+	banned, err := limiter.Banned("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+
+	require.NoError(t, limiter.Ban("test_id", time.Hour))
+
+	banned, err = limiter.Banned("test_id")
+	require.NoError(t, err)
+	assert.True(t, banned > 59*time.Minute)
+
+	// Banned ids are denied without being counted.
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	attempted, err := limiter.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+
+	// Other ids are not affected.
+	ok, err = limiter.Attempt("some_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	require.NoError(t, limiter.Unban("test_id"))
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,243 @@
+package speedbump
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// attemptManyScript attempts requests for multiple ids. For every id, KEYS has
+// its counter, ban and override keys, and ARGV has its cost after the max and
+// the expiration of counters in milliseconds. For every id, it returns whether
+// the attempt was allowed, the counter after the attempt, the max that applies
+// to the id and whether the id is banned.
+//
+// Attempts over the limit and attempts of banned ids are not counted, like in
+// RateLimiter.Attempt.
+var attemptManyScript = redis.NewScript(`
+local max = tonumber(ARGV[1])
+local ttl = tonumber(ARGV[2])
+local results = {}
+
+for i = 1, #KEYS / 3 do
+  local counter, ban, override = KEYS[3 * i - 2], KEYS[3 * i - 1], KEYS[3 * i]
+  local cost = tonumber(ARGV[2 + i])
+  local limit = tonumber(redis.call("GET", override) or max)
+  local attempted = tonumber(redis.call("GET", counter) or 0)
+  local allowed = 0
+  local banned = redis.call("EXISTS", ban)
+
+  if banned == 1 then
+    attempted = limit
+  elseif attempted + cost <= limit then
+    attempted = redis.call("INCRBY", counter, cost)
+    redis.call("PEXPIRE", counter, ttl)
+    allowed = 1
+  end
+
+  results[i] = {allowed, attempted, limit, banned}
+end
+
+return results
+`)
+
+// attemptResult is the result of an attempt, before handling errors.
+type attemptResult struct {
+	attempted int64
+	max       int64
+	ok        bool
+	banned    bool
+	// cached is set for results answered by the denied cache.
+	cached bool
+	// key and cost are set for attempts that were counted, so that they can be
+	// refunded.
+	key  string
+	cost int64
+	// err is set for batch results that failed after they were decided, such
+	// as when escalating, so that the failure mode only applies to them.
+	err error
+}
+
+// AttemptMany attempts requests for multiple ids with a single call to the
+// Redis server, such as the user, organization and IP address of a request.
+// It returns a decision per id, in the same order as the ids.
+//
+// Every attempt costs one request, unless costs are provided, in which case
+// they must have the same length as ids. An attempt is only allowed if its
+// whole cost fits in what is left of the limit, and it is counted entirely.
+//
+// The keys of all the ids are accessed by a single script, so on a Redis
+// Cluster they have to be in the same hash slot, for example by using a
+// namespace with a hash tag such as "{api}".
+//
+// If the call fails, every decision is made by the failure mode of the
+// limiter. See WithFailureMode.
+func (r *RateLimiter) AttemptMany(ctx context.Context, ids []string, costs []int64) ([]Decision, error) {
+	if r.serverTime {
+		return nil, ErrServerTime
+	}
+
+	if costs != nil && len(costs) != len(ids) {
+		return nil, fmt.Errorf("speedbump: got %d costs for %d ids", len(costs), len(ids))
+	}
+
+	for i, cost := range costs {
+		if cost < 1 {
+			return nil, fmt.Errorf("speedbump: invalid cost %d for id %q", cost, ids[i])
+		}
+	}
+
+	_, span := r.startSpanWith(ctx, "AttemptMany", AttributeCount.Int(len(ids)))
+
+	start := time.Now()
+	results, err := r.attemptMany(ids, costs)
+
+	if err != nil {
+		r.observeError(err)
+		recordError(span, err)
+		recordFailure(span, r.failureMode)
+	}
+
+	decisions := make([]Decision, len(ids))
+	events := make([]Event, len(ids))
+
+	var firstErr error
+	for i, id := range ids {
+		resultErr := err
+		if results[i].cached {
+			resultErr = nil
+		}
+
+		if results[i].err != nil {
+			resultErr = results[i].err
+			r.observeError(resultErr)
+			recordError(span, resultErr)
+		}
+
+		decision, event, decisionErr := r.finish(id, start, results[i], resultErr)
+		if decisionErr != nil && firstErr == nil {
+			firstErr = decisionErr
+		}
+
+		decisions[i] = decision
+		events[i] = event
+	}
+
+	r.recordDecisions(decisions...)
+	span.End()
+
+	for i := range ids {
+		r.notify(events[i], results[i].ok)
+	}
+
+	return decisions, firstErr
+}
+
+// attemptMany performs a batch of attempts without handling errors.
+func (r *RateLimiter) attemptMany(ids []string, costs []int64) ([]attemptResult, error) {
+	current := r.current()
+	results := make([]attemptResult, len(ids))
+
+	periodHasher, cacheable := current.hasher.(PeriodHasher)
+	cacheable = cacheable && r.denied != nil
+
+	keys := []string{}
+	args := []interface{}{current.max, milliseconds(current.hasher.Duration())}
+	pending := []int{}
+
+	for i, id := range ids {
+		if cacheable {
+			if entry, ok := r.denied.get(id, periodHasher.Now()); ok {
+				results[i] = attemptResult{attempted: entry.attempted, max: entry.max, cached: true}
+				continue
+			}
+		}
+
+		cost := int64(1)
+		if costs != nil {
+			cost = costs[i]
+		}
+
+		keys = append(keys, r.hashWith(current.hasher, id), r.banKey(id), r.overrideKey(id))
+		args = append(args, cost)
+		pending = append(pending, i)
+	}
+
+	if len(pending) == 0 {
+		return results, nil
+	}
+
+	start := time.Now()
+	reply, err := attemptManyScript.Run(r.redisClient, keys, args...).Result()
+	r.observeLatency("script", start)
+
+	if err != nil {
+		return results, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != len(pending) {
+		return results, fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+	}
+
+	// The whole reply is parsed first, so that a malformed one doesn't leave
+	// some ids decided and the others failed.
+	parsed := make([]attemptResult, len(pending))
+	for j := range pending {
+		if parsed[j], ok = parseBatchResult(values[j]); !ok {
+			return results, fmt.Errorf("speedbump: unexpected reply from script: %v", values[j])
+		}
+	}
+
+	for j, i := range pending {
+		result := parsed[j]
+		if result.ok {
+			result.key, result.cost = keys[3*j], args[2+j].(int64)
+		}
+
+		// Only ids that reached their limit committed an offence, not the ones
+		// whose cost was larger than what they had left. A failure only affects
+		// the id that failed, since the others were already decided.
+		overLimit := !result.ok && !result.banned && result.attempted >= result.max
+		if overLimit {
+			result.err = r.escalate(ids[i])
+		}
+
+		results[i] = result
+
+		// Ids that can't make any more attempts are cached like in attempt.
+		if cacheable && overLimit && result.err == nil {
+			r.denied.add(ids[i], deniedEntry{
+				until:     periodHasher.PeriodEnd(),
+				attempted: result.attempted,
+				max:       result.max,
+			}, periodHasher.Now())
+		}
+	}
+
+	return results, nil
+}
+
+// parseBatchResult converts the result of an attempt returned by the script.
+func parseBatchResult(value interface{}) (attemptResult, bool) {
+	fields, ok := value.([]interface{})
+	if !ok || len(fields) != 4 {
+		return attemptResult{}, false
+	}
+
+	ints := make([]int64, 4)
+	for i, field := range fields {
+		if ints[i], ok = field.(int64); !ok {
+			return attemptResult{}, false
+		}
+	}
+
+	return attemptResult{
+		ok:        ints[0] == 1,
+		attempted: ints[1],
+		max:       ints[2],
+		banned:    ints[3] == 1,
+	}, true
+}
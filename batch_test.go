@@ -0,0 +1,101 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAttemptMany(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 3 requests/min.
+	metrics := &recordingMetrics{}
+	listener := &recordingListener{}
+	limiter := NewLimiter(
+		client, PerMinuteHasher{}, 3,
+		WithNamespace("api"),
+		WithMetrics(metrics),
+		WithListener(listener),
+	)
+
+	require.NoError(t, limiter.SetOverride("org", 10, time.Hour))
+	require.NoError(t, limiter.Ban("banned", time.Hour))
+	makeNAttempts(t, limiter, "ip", 3)
+	metrics.operations = nil
+
+	decisions, err := limiter.AttemptMany(context.Background(), []string{"user", "org", "ip", "banned"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []Decision{
+		{Allowed: true, Attempted: 1, Max: 3, Remaining: 2},
+		{Allowed: true, Attempted: 1, Max: 10, Remaining: 9},
+		{Allowed: false, Limited: true, Attempted: 3, Max: 3, Remaining: 0},
+		// Banned ids are denied without being counted.
+		{Allowed: false, Limited: true, Attempted: 3, Max: 3, Remaining: 0},
+	}, withoutReceipts(decisions...))
+	assert.Equal(t, []bool{true, true, false, false}, refundable(decisions))
+
+	// The whole batch is a single call to the Redis server.
+	assert.Equal(t, []string{"script"}, metrics.operations)
+	assert.Equal(t, []string{"allowed", "allowed", "allowed", "allowed", "allowed", "limited", "limited"}, listener.kinds)
+
+	// Counters are shared with Attempt.
+	attempted, err := limiter.Attempted("user")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), attempted)
+
+	attempted, err = limiter.Attempted("banned")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), attempted)
+}
+
+func TestAttemptManyCosts(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 5 requests/min.
+	limiter := NewLimiter(client, PerMinuteHasher{}, 5)
+
+	decisions, err := limiter.AttemptMany(context.Background(), []string{"a", "b", "a"}, []int64{3, 5, 3})
+	require.NoError(t, err)
+	assert.Equal(t, []Decision{
+		{Allowed: true, Attempted: 3, Max: 5, Remaining: 2},
+		{Allowed: true, Attempted: 5, Max: 5, Remaining: 0},
+		// The cost doesn't fit in what is left, so nothing is counted.
+		{Allowed: false, Limited: true, Attempted: 3, Max: 5, Remaining: 2},
+	}, withoutReceipts(decisions...))
+	assert.Equal(t, []bool{true, true, false}, refundable(decisions))
+
+	_, err = limiter.AttemptMany(context.Background(), []string{"a"}, []int64{1, 2})
+	assert.EqualError(t, err, "speedbump: got 2 costs for 1 ids")
+
+	_, err = limiter.AttemptMany(context.Background(), []string{"a"}, []int64{0})
+	assert.EqualError(t, err, `speedbump: invalid cost 0 for id "a"`)
+
+	decisions, err = limiter.AttemptMany(context.Background(), nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, decisions)
+}
+
+func TestAttemptManyFailureModes(t *testing.T) {
+	metrics := &recordingMetrics{}
+	limiter := NewLimiter(createBrokenClient(), PerMinuteHasher{}, 5, WithMetrics(metrics))
+
+	decisions, err := limiter.AttemptMany(context.Background(), []string{"a", "b"}, nil)
+	assert.Error(t, err)
+	assert.Len(t, decisions, 2)
+	assert.False(t, decisions[0].Allowed)
+	// The failed call is only reported once.
+	assert.Equal(t, []string{"connection"}, metrics.errors)
+
+	limiter = NewLimiter(createBrokenClient(), PerMinuteHasher{}, 5, WithFailureMode(FailOpen))
+
+	decisions, err = limiter.AttemptMany(context.Background(), []string{"a", "b"}, nil)
+	require.NoError(t, err)
+	assert.True(t, decisions[0].Allowed)
+	assert.True(t, decisions[1].Allowed)
+}
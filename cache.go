@@ -0,0 +1,103 @@
+package speedbump
+
+import (
+	"sync"
+	"time"
+)
+
+// WithDeniedCache remembers the ids that reached the limit until their period
+// ends, and denies their attempts without calling the Redis server. This cuts
+// the load on the server when abusive clients keep sending requests after
+// being limited.
+//
+// At most size ids are remembered. When the cache is full, ids whose period
+// ended are removed, and new ids are not cached until there is room again.
+//
+// The cache is only used with hashers that implement PeriodHasher, since it
+// needs to know exactly when the period ends. It is local to the limiter, so
+// changes made through other limiters, such as resetting the counter of an id
+// or raising its max, are only noticed once the period ends.
+func WithDeniedCache(size int) Option {
+	return func(r *RateLimiter) {
+		r.denied = &deniedCache{
+			size:    size,
+			entries: map[string]deniedEntry{},
+		}
+	}
+}
+
+// deniedCache is an in-process cache of denied ids.
+type deniedCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]deniedEntry
+}
+
+// deniedEntry is an id that is denied until its period ends.
+type deniedEntry struct {
+	until     time.Time
+	attempted int64
+	max       int64
+}
+
+// get returns the entry of an id, if it is denied at the provided time.
+func (c *deniedCache) get(id string, now time.Time) (deniedEntry, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[id]
+	if !ok {
+		return entry, false
+	}
+
+	if !now.Before(entry.until) {
+		delete(c.entries, id)
+		return entry, false
+	}
+
+	return entry, true
+}
+
+// add remembers that an id is denied until the provided time.
+func (c *deniedCache) add(id string, entry deniedEntry, now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, ok := c.entries[id]; !ok && len(c.entries) >= c.size {
+		for key, existing := range c.entries {
+			if !now.Before(existing.until) {
+				delete(c.entries, key)
+			}
+		}
+
+		if len(c.entries) >= c.size {
+			return
+		}
+	}
+
+	c.entries[id] = entry
+}
+
+// remove forgets an id.
+func (c *deniedCache) remove(id string) {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, id)
+}
+
+// clear forgets every id.
+func (c *deniedCache) clear() {
+	if c == nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.entries = map[string]deniedEntry{}
+}
@@ -0,0 +1,86 @@
+package speedbump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeniedCache(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 1 request/min with a mock clock.
+	mock := clock.NewMock()
+	metrics := &recordingMetrics{}
+	limiter := NewLimiter(
+		client, PerMinuteHasher{Clock: mock}, 1,
+		WithDeniedCache(10),
+		WithMetrics(metrics),
+	)
+
+	makeNAttempts(t, limiter, "test_id", 2)
+	assert.Equal(t, []string{"get", "incr", "get"}, metrics.operations)
+
+	// Denied ids are answered locally, even if the counter changes meanwhile.
+	require.NoError(t, client.FlushAll().Err())
+
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Equal(t, []string{"get", "incr", "get"}, metrics.operations)
+
+	// The cache ends with the period.
+	mock.Add(time.Minute)
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Resetting the counter clears the cache.
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, limiter.Reset("test_id"))
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// So does raising the max.
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	require.NoError(t, limiter.SetOverride("test_id", 2, 0))
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestDeniedCacheSize(t *testing.T) {
+	cache := &deniedCache{size: 2, entries: map[string]deniedEntry{}}
+	now := time.Unix(0, 0)
+
+	cache.add("a", deniedEntry{until: now.Add(time.Second)}, now)
+	cache.add("b", deniedEntry{until: now.Add(time.Minute)}, now)
+	cache.add("c", deniedEntry{until: now.Add(time.Minute)}, now)
+
+	// The cache is full, so c is not cached.
+	_, ok := cache.get("c", now)
+	assert.False(t, ok)
+
+	// Once a expires, there is room for c.
+	later := now.Add(time.Second)
+	cache.add("c", deniedEntry{until: now.Add(time.Minute)}, later)
+
+	_, ok = cache.get("a", later)
+	assert.False(t, ok)
+	_, ok = cache.get("b", later)
+	assert.True(t, ok)
+	_, ok = cache.get("c", later)
+	assert.True(t, ok)
+}
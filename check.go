@@ -0,0 +1,68 @@
+package speedbump
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/redis.v5"
+)
+
+// Check decides whether an attempt for an id would be allowed, without counting
+// it. Together with Count, it allows counting only some outcomes, such as
+// failed logins: the request is checked before it is handled, and counted
+// afterwards if it failed.
+//
+// The decision is made like in Decide, including bans, overrides, escalation,
+// shadow mode and the failure mode, and it is reported to metrics and
+// listeners. Since requests are only counted once they are handled, requests
+// that are handled at the same time may go over the limit before any of them
+// is counted.
+func (r *RateLimiter) Check(ctx context.Context, id string) (Decision, error) {
+	return r.decide(ctx, "Check", id, false)
+}
+
+// Count counts an attempt for an id without checking the limit, such as a
+// failed login that was allowed by Check.
+func (r *RateLimiter) Count(ctx context.Context, id string) error {
+	_, span := r.startSpan(ctx, "Count", id)
+
+	current := r.current()
+	if r.serverTime {
+		_, err := r.runServerTime(current, id, "count")
+		r.finishCount(span, id, err)
+
+		return err
+	}
+
+	hash := r.hashWith(current.hasher, id)
+
+	// The counter is incremented and expired in a transaction, like in
+	// Attempt.
+	start := time.Now()
+	err := r.redisClient.Watch(func(tx *redis.Tx) error {
+		_, err := tx.Pipelined(func(pipe *redis.Pipeline) error {
+			pipe.Incr(hash)
+			pipe.PExpire(hash, current.hasher.Duration())
+
+			return nil
+		})
+
+		return err
+	})
+	r.observeLatency("incr", start)
+	r.finishCount(span, id, err)
+
+	return err
+}
+
+// finishCount reports the outcome of Count.
+func (r *RateLimiter) finishCount(span trace.Span, id string, err error) {
+	if err != nil {
+		r.observeError(err)
+	} else {
+		r.recordUsage(usageEntry{id: id, cost: 1})
+	}
+
+	endSpan(span, err)
+}
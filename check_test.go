@@ -0,0 +1,68 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckCount(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 requests/min.
+	listener := &recordingListener{}
+	limiter := NewLimiter(client, PerMinuteHasher{}, 2, WithListener(listener))
+
+	ctx := context.Background()
+
+	// Checks don't count attempts.
+	for i := 0; i < 3; i++ {
+		decision, err := limiter.Check(ctx, "test_id")
+		require.NoError(t, err)
+		assert.True(t, decision.Allowed)
+		assert.False(t, decision.Refundable())
+	}
+
+	attempted, err := limiter.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+
+	require.NoError(t, limiter.Count(ctx, "test_id"))
+	require.NoError(t, limiter.Count(ctx, "test_id"))
+
+	decision, err := limiter.Check(ctx, "test_id")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, int64(2), decision.Attempted)
+	assert.Equal(t, []string{"allowed", "allowed", "allowed", "limited"}, listener.kinds)
+
+	// Counts are not limited, and expire like attempts.
+	require.NoError(t, limiter.Count(ctx, "test_id"))
+
+	attempted, err = limiter.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), attempted)
+
+	ttl, err := client.TTL(limiter.hash("test_id")).Result()
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute, ttl, float64(time.Second))
+
+	// Bans apply to checks too.
+	require.NoError(t, limiter.Ban("banned", time.Minute))
+
+	decision, err = limiter.Check(ctx, "banned")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+}
+
+func TestCount(t *testing.T) {
+	metrics := &recordingMetrics{}
+	limiter := NewLimiter(createBrokenClient(), PerMinuteHasher{}, 2, WithMetrics(metrics))
+
+	assert.Error(t, limiter.Count(context.Background(), "test_id"))
+	assert.Len(t, metrics.errors, 1)
+}
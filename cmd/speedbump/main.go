@@ -0,0 +1,400 @@
+// Command speedbump inspects and manages the limits stored by Speedbump
+// limiters in a Redis server.
+//
+// Usage:
+//
+//	speedbump [flags] status <id>
+//	speedbump [flags] reset <id>
+//	speedbump [flags] ban [-for duration] <id>
+//	speedbump [flags] unban <id>
+//	speedbump [flags] top [-n count]
+//	speedbump [flags] usage [-days n] [-hourly] <id>
+//
+// The flags must match the limiter being managed:
+//
+//	-addr       address of the Redis server (default "localhost:6379")
+//	-password   password of the Redis server
+//	-db         Redis database
+//	-namespace  namespace of the limiter (see speedbump.WithNamespace), which
+//	            top requires
+//	-period     period of the limiter: second, minute or hour (default "minute")
+//	-max        maximum number of attempts per period (default 10)
+//	-json       print output as JSON
+//
+// For example, to lift a ban on an IP address of a login limiter:
+//
+//	speedbump -namespace login -period hour -max 5 unban 1.2.3.4
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"gopkg.in/redis.v5"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// errUsage is returned by commands that were called with invalid arguments.
+var errUsage = errors.New("invalid arguments")
+
+// errNoNamespace is returned by top for limiters without a namespace, whose
+// counters can't be told apart from the ones of other limiters.
+var errNoNamespace = errors.New("top requires -namespace")
+
+// command is a subcommand of the tool.
+type command struct {
+	usage string
+	run   func(cli *cli, args []string) error
+}
+
+// commands are the subcommands of the tool, by name.
+var commands = map[string]command{
+	"status": {"status <id>", (*cli).status},
+	"reset":  {"reset <id>", (*cli).reset},
+	"ban":    {"ban [-for duration] <id>", (*cli).ban},
+	"unban":  {"unban <id>", (*cli).unban},
+	"top":    {"top [-n count]", (*cli).top},
+	"usage":  {"usage [-days n] [-hourly] <id>", (*cli).usage},
+}
+
+// cli holds the state shared by the subcommands.
+type cli struct {
+	limiter *speedbump.RateLimiter
+	json    bool
+	stdout  io.Writer
+	stderr  io.Writer
+}
+
+// run runs the tool with the provided arguments and returns its exit code.
+func run(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("speedbump", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	addr := flags.String("addr", "localhost:6379", "address of the Redis server")
+	password := flags.String("password", "", "password of the Redis server")
+	db := flags.Int("db", 0, "Redis database")
+	namespace := flags.String("namespace", "", "namespace of the limiter")
+	period := flags.String("period", "minute", "period of the limiter: second, minute or hour")
+	max := flags.Int64("max", 10, "maximum number of attempts per period")
+	asJSON := flags.Bool("json", false, "print output as JSON")
+
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "Usage: speedbump [flags] <command> [arguments]")
+		fmt.Fprintln(stderr, "\nCommands:")
+		for _, name := range []string{"status", "reset", "ban", "unban", "top", "usage"} {
+			fmt.Fprintf(stderr, "  %s\n", commands[name].usage)
+		}
+		fmt.Fprintln(stderr, "\nFlags:")
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return 2
+	}
+
+	cmd, ok := commands[flags.Arg(0)]
+	if !ok {
+		fmt.Fprintf(stderr, "speedbump: unknown command %q\n", flags.Arg(0))
+		flags.Usage()
+		return 2
+	}
+
+	hasher, err := speedbump.ParseHasher(*period)
+	if err != nil {
+		fmt.Fprintf(stderr, "speedbump: %v\n", err)
+		return 2
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:     *addr,
+		Password: *password,
+		DB:       *db,
+	})
+	defer client.Close()
+
+	c := &cli{
+		limiter: speedbump.NewLimiter(
+			client, hasher, *max,
+			speedbump.WithNamespace(*namespace),
+			speedbump.WithUsageHistory(speedbump.UsageHistory{}),
+		),
+		json:   *asJSON,
+		stdout: stdout,
+		stderr: stderr,
+	}
+
+	if err := cmd.run(c, flags.Args()[1:]); err != nil {
+		if err == errUsage {
+			fmt.Fprintf(stderr, "Usage: speedbump [flags] %s\n", cmd.usage)
+			return 2
+		}
+
+		fmt.Fprintf(stderr, "speedbump: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// status prints the state of an id.
+func (c *cli) status(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	id := args[0]
+
+	attempted, err := c.limiter.Attempted(id)
+	if err != nil {
+		return err
+	}
+
+	max, left, banned, err := c.limits(id)
+	if err != nil {
+		return err
+	}
+
+	status := struct {
+		ID        string    `json:"id"`
+		Attempted int64     `json:"attempted"`
+		Max       int64     `json:"max"`
+		Remaining int64     `json:"remaining"`
+		Reset     time.Time `json:"reset"`
+		BannedFor float64   `json:"banned_for_seconds"`
+	}{
+		ID:        id,
+		Attempted: attempted,
+		Max:       max,
+		Remaining: left,
+		Reset:     time.Now().Add(c.limiter.RetryAfter()).Truncate(time.Second),
+		BannedFor: banned.Seconds(),
+	}
+
+	if c.json {
+		return json.NewEncoder(c.stdout).Encode(status)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "id:\t%s\n", status.ID)
+	fmt.Fprintf(w, "attempted:\t%d\n", status.Attempted)
+	fmt.Fprintf(w, "max:\t%d\n", status.Max)
+	fmt.Fprintf(w, "remaining:\t%d\n", status.Remaining)
+	fmt.Fprintf(w, "reset:\t%s\n", status.Reset.Format(time.RFC3339))
+	fmt.Fprintf(w, "banned for:\t%s\n", formatBan(banned))
+
+	return w.Flush()
+}
+
+// limits returns the max of an id, taking its override into account, the
+// attempts it has left and how long it is banned for. Banned ids have no
+// attempts left.
+func (c *cli) limits(id string) (int64, int64, time.Duration, error) {
+	max, ok, err := c.limiter.Override(id)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if !ok {
+		max = c.limiter.Max()
+	}
+
+	left, err := c.limiter.Left(id)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	banned, err := c.limiter.Banned(id)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	if banned > 0 {
+		left = 0
+	}
+
+	return max, left, banned, nil
+}
+
+// formatBan formats how long an id is banned for, or "-" if it isn't.
+func formatBan(banned time.Duration) string {
+	if banned <= 0 {
+		return "-"
+	}
+
+	return banned.Truncate(time.Second).String()
+}
+
+// reset clears the counter of an id.
+func (c *cli) reset(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	if err := c.limiter.Reset(args[0]); err != nil {
+		return err
+	}
+
+	return c.done("reset", args[0])
+}
+
+// ban bans an id.
+func (c *cli) ban(args []string) error {
+	flags := flag.NewFlagSet("ban", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	duration := flags.Duration("for", time.Hour, "how long the id is banned for")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || *duration <= 0 {
+		return errUsage
+	}
+
+	if err := c.limiter.Ban(flags.Arg(0), *duration); err != nil {
+		return err
+	}
+
+	return c.done("banned", flags.Arg(0))
+}
+
+// unban lifts the ban on an id.
+func (c *cli) unban(args []string) error {
+	if len(args) != 1 {
+		return errUsage
+	}
+
+	if err := c.limiter.Unban(args[0]); err != nil {
+		return err
+	}
+
+	return c.done("unbanned", args[0])
+}
+
+// top prints the ids with the most attempts in the current period. It requires
+// a namespace. See speedbump.RateLimiter.Counters.
+func (c *cli) top(args []string) error {
+	flags := flag.NewFlagSet("top", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	n := flags.Int("n", 10, "number of ids to print")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 || *n < 0 {
+		return errUsage
+	}
+
+	if c.limiter.Namespace() == "" {
+		return errNoNamespace
+	}
+
+	counters, err := c.limiter.Counters(*n)
+	if err != nil {
+		return err
+	}
+
+	type entry struct {
+		ID        string  `json:"id"`
+		Attempted int64   `json:"attempted"`
+		Max       int64   `json:"max"`
+		Remaining int64   `json:"remaining"`
+		BannedFor float64 `json:"banned_for_seconds"`
+
+		banned time.Duration
+	}
+
+	entries := make([]entry, 0, len(counters))
+	for _, counter := range counters {
+		max, left, banned, err := c.limits(counter.ID)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, entry{
+			ID:        counter.ID,
+			Attempted: counter.Attempted,
+			Max:       max,
+			Remaining: left,
+			BannedFor: banned.Seconds(),
+			banned:    banned,
+		})
+	}
+
+	if c.json {
+		return json.NewEncoder(c.stdout).Encode(entries)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tATTEMPTED\tMAX\tREMAINING\tBANNED FOR")
+	for _, entry := range entries {
+		fmt.Fprintf(
+			w, "%s\t%d\t%d\t%d\t%s\n",
+			entry.ID, entry.Attempted, entry.Max, entry.Remaining, formatBan(entry.banned),
+		)
+	}
+
+	return w.Flush()
+}
+
+// usage prints the number of attempts counted for an id every day, or every
+// hour, of the last days. The limiter must have been created with
+// speedbump.WithUsageHistory.
+func (c *cli) usage(args []string) error {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	flags.SetOutput(c.stderr)
+	days := flags.Int("days", 30, "number of days to print")
+	hourly := flags.Bool("hourly", false, "print hourly usage instead of daily")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 1 || *days < 1 {
+		return errUsage
+	}
+
+	id := flags.Arg(0)
+	to := time.Now().UTC()
+	from := to.AddDate(0, 0, 1-*days)
+
+	query, layout := c.limiter.Usage, "2006-01-02"
+	if *hourly {
+		query, layout = c.limiter.HourlyUsage, "2006-01-02 15:00"
+	}
+
+	buckets, err := query(id, from, to)
+	if err != nil {
+		return err
+	}
+
+	if c.json {
+		return json.NewEncoder(c.stdout).Encode(buckets)
+	}
+
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tCOUNT")
+	for _, bucket := range buckets {
+		fmt.Fprintf(w, "%s\t%d\n", bucket.Start.Format(layout), bucket.Count)
+	}
+
+	return w.Flush()
+}
+
+// done prints the result of a command that changed the state of an id.
+func (c *cli) done(action, id string) error {
+	if c.json {
+		return json.NewEncoder(c.stdout).Encode(map[string]string{
+			"id":     id,
+			"result": action,
+		})
+	}
+
+	_, err := fmt.Fprintf(c.stdout, "%s %s\n", action, id)
+
+	return err
+}
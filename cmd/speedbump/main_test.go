@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/redis.v5"
+)
+
+func createClient() *redis.Client {
+	return redis.NewClient(&redis.Options{
+		Addr:     redisAddr(),
+		Password: "",
+		DB:       0,
+	})
+}
+
+func redisAddr() string {
+	if os.Getenv("WERCKER_REDIS_HOST") != "" {
+		return os.Getenv("WERCKER_REDIS_HOST") + ":6379"
+	}
+
+	return "localhost:6379"
+}
+
+func teardown(t *testing.T, client *redis.Client) {
+	// Flush Redis.
+	require.NoError(t, client.FlushAll().Err())
+}
+
+// runCommand runs the tool against the test server and returns its exit code
+// and output.
+func runCommand(args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-addr", redisAddr(), "-namespace", "login", "-max", "3"}, args...)
+	code := run(args, &stdout, &stderr)
+
+	return code, stdout.String(), stderr.String()
+}
+
+func TestRun(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	limiter := speedbump.NewLimiter(
+		client, speedbump.PerMinuteHasher{}, 3, speedbump.WithNamespace("login"),
+	)
+	for _, id := range []string{"1.2.3.4", "1.2.3.4", "5.6.7.8"} {
+		_, err := limiter.Attempt(id)
+		require.NoError(t, err)
+	}
+
+	code, stdout, stderr := runCommand("top")
+	require.Equal(t, 0, code, stderr)
+	assert.Equal(t, ""+
+		"ID       ATTEMPTED  MAX  REMAINING  BANNED FOR\n"+
+		"1.2.3.4  2          3    1          -\n"+
+		"5.6.7.8  1          3    2          -\n", stdout)
+
+	code, stdout, _ = runCommand("reset", "1.2.3.4")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "reset 1.2.3.4\n", stdout)
+
+	code, stdout, _ = runCommand("-json", "top", "-n", "5")
+	assert.Equal(t, 0, code)
+	assert.JSONEq(t, `[{"id":"5.6.7.8","attempted":1,"max":3,"remaining":2,"banned_for_seconds":0}]`, stdout)
+
+	code, stdout, _ = runCommand("-json", "ban", "-for", "1m", "1.2.3.4")
+	assert.Equal(t, 0, code)
+	assert.JSONEq(t, `{"id":"1.2.3.4","result":"banned"}`, stdout)
+
+	code, stdout, _ = runCommand("-json", "status", "1.2.3.4")
+	require.Equal(t, 0, code)
+
+	var status struct {
+		Attempted int64   `json:"attempted"`
+		Max       int64   `json:"max"`
+		Remaining int64   `json:"remaining"`
+		BannedFor float64 `json:"banned_for_seconds"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &status))
+	assert.Equal(t, int64(0), status.Attempted)
+	assert.Equal(t, int64(3), status.Max)
+	assert.Equal(t, int64(0), status.Remaining)
+	assert.InDelta(t, 60, status.BannedFor, 1)
+
+	code, stdout, _ = runCommand("unban", "1.2.3.4")
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "unbanned 1.2.3.4\n", stdout)
+
+	code, stdout, _ = runCommand("status", "1.2.3.4")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "banned for:  -")
+}
+
+func TestRunOverride(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	limiter := speedbump.NewLimiter(
+		client, speedbump.PerMinuteHasher{}, 3, speedbump.WithNamespace("login"),
+	)
+	require.NoError(t, limiter.SetOverride("1.2.3.4", 10, time.Hour))
+	for _, id := range []string{"1.2.3.4", "1.2.3.4", "1.2.3.4", "1.2.3.4", "5.6.7.8"} {
+		_, err := limiter.Attempt(id)
+		require.NoError(t, err)
+	}
+	require.NoError(t, limiter.Ban("5.6.7.8", time.Hour))
+
+	// Overrides raise the max, and banned ids have nothing left.
+	code, stdout, stderr := runCommand("top")
+	require.Equal(t, 0, code, stderr)
+	assert.Regexp(t, "^"+
+		"ID       ATTEMPTED  MAX  REMAINING  BANNED FOR\n"+
+		"1.2.3.4  4          10   6          -\n"+
+		"5.6.7.8  1          3    0          (1h0m0s|59m59s)\n$", stdout)
+
+	code, stdout, _ = runCommand("status", "1.2.3.4")
+	require.Equal(t, 0, code)
+	assert.Contains(t, stdout, "max:         10\n")
+	assert.Contains(t, stdout, "remaining:   6\n")
+
+	code, stdout, _ = runCommand("status", "5.6.7.8")
+	require.Equal(t, 0, code)
+	assert.Contains(t, stdout, "remaining:   0\n")
+	assert.Regexp(t, "banned for:  (1h0m0s|59m59s)\n", stdout)
+}
+
+func TestRunTopSharedDatabase(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	// Limiters with and without a namespace share the database.
+	login := speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 3, speedbump.WithNamespace("login"))
+	search := speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 3, speedbump.WithNamespace("search"))
+	global := speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 3)
+	for _, limiter := range []*speedbump.RateLimiter{login, search, search, global} {
+		_, err := limiter.Attempt("1.2.3.4")
+		require.NoError(t, err)
+	}
+
+	// Only the counters of the namespace are listed.
+	code, stdout, stderr := runCommand("-json", "top")
+	require.Equal(t, 0, code, stderr)
+	assert.JSONEq(t, `[{"id":"1.2.3.4","attempted":1,"max":3,"remaining":2,"banned_for_seconds":0}]`, stdout)
+
+	var out, errOut bytes.Buffer
+	code = run([]string{"-addr", redisAddr(), "-max", "3", "top"}, &out, &errOut)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "speedbump: top requires -namespace\n", errOut.String())
+	assert.Empty(t, out.String())
+}
+
+func TestRunUsageHistory(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	limiter := speedbump.NewLimiter(
+		client, speedbump.PerMinuteHasher{}, 3,
+		speedbump.WithNamespace("login"),
+		speedbump.WithUsageHistory(speedbump.UsageHistory{}),
+	)
+	for i := 0; i < 2; i++ {
+		_, err := limiter.Attempt("1.2.3.4")
+		require.NoError(t, err)
+	}
+
+	code, stdout, stderr := runCommand("-json", "usage", "-days", "2", "1.2.3.4")
+	require.Equal(t, 0, code, stderr)
+
+	var buckets []speedbump.UsageBucket
+	require.NoError(t, json.Unmarshal([]byte(stdout), &buckets))
+	require.Len(t, buckets, 2)
+	assert.Equal(t, int64(0), buckets[0].Count)
+	assert.Equal(t, int64(2), buckets[1].Count)
+
+	code, stdout, _ = runCommand("usage", "-days", "1", "1.2.3.4")
+	assert.Equal(t, 0, code)
+	assert.Contains(t, stdout, "START")
+	assert.Contains(t, stdout, "  2\n")
+
+	code, _, stderr = runCommand("usage", "-days", "0", "1.2.3.4")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "usage [-days n] [-hourly] <id>")
+}
+
+func TestRunUsage(t *testing.T) {
+	code, _, stderr := runCommand()
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "Usage: speedbump")
+
+	code, _, stderr = runCommand("explode")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, `unknown command "explode"`)
+
+	code, _, stderr = runCommand("reset")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "Usage: speedbump [flags] reset <id>")
+
+	code, _, stderr = runCommand("-period", "fortnight", "reset", "id")
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr, "fortnight")
+}
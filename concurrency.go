@@ -0,0 +1,295 @@
+package speedbump
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"gopkg.in/redis.v5"
+)
+
+// ErrLeaseExpired is returned when extending a lease that already expired, in
+// which case its slot may have been taken by someone else.
+var ErrLeaseExpired = errors.New("speedbump: lease expired")
+
+// leaseNow is the part of the lease scripts that determines the current time
+// in milliseconds. It is the time in ARGV[1] if there is one, or else the time
+// of the Redis server, so that instances with skewed clocks don't expire each
+// other's leases.
+const leaseNow = `
+local now = tonumber(ARGV[1])
+if not now then
+  -- Writes after reading the time are only allowed when commands are
+  -- replicated instead of the script.
+  redis.replicate_commands()
+
+  local time = redis.call("TIME")
+  now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+end
+`
+
+// acquireScript takes a slot for an id if there is one available. KEYS has the
+// leases of the id, and ARGV has the current time, or an empty string to use
+// the time of the server, the expiration of the lease in milliseconds, the max
+// and the token of the lease. Leases that expired are removed first, so that
+// slots held by crashed processes are eventually freed.
+var acquireScript = redis.NewScript(leaseNow + `
+local ttl = tonumber(ARGV[2])
+
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now)
+
+if redis.call("ZCARD", KEYS[1]) >= tonumber(ARGV[3]) then
+  return 0
+end
+
+redis.call("ZADD", KEYS[1], now + ttl, ARGV[4])
+redis.call("PEXPIRE", KEYS[1], ttl)
+
+return 1
+`)
+
+// extendScript renews a lease, if it didn't expire. It takes the same
+// arguments as acquireScript, except for the max.
+var extendScript = redis.NewScript(leaseNow + `
+local ttl = tonumber(ARGV[2])
+local expiry = redis.call("ZSCORE", KEYS[1], ARGV[3])
+
+if not expiry or tonumber(expiry) <= now then
+  redis.call("ZREM", KEYS[1], ARGV[3])
+  return 0
+end
+
+redis.call("ZADD", KEYS[1], now + ttl, ARGV[3])
+redis.call("PEXPIRE", KEYS[1], ttl)
+
+return 1
+`)
+
+// ConcurrencyLimiter is a Redis-backed limiter of the number of requests that
+// are in progress at the same time for an id, such as "at most 3 exports per
+// customer".
+//
+// Every request acquires a lease, which holds one of the slots of the id until
+// it is released. Leases expire after a TTL, so that slots held by processes
+// that crashed are eventually freed. Requests that take longer than the TTL
+// should extend their lease, see Lease.KeepAlive.
+type ConcurrencyLimiter struct {
+	redisClient *redis.Client
+	max         int64
+	ttl         time.Duration
+	namespace   string
+	clock       clock.Clock
+	// localTime determines whether leases expire according to the clock
+	// instead of the clock of the Redis server.
+	localTime bool
+}
+
+// ConcurrencyOption configures optional behavior of a ConcurrencyLimiter.
+type ConcurrencyOption func(*ConcurrencyLimiter)
+
+// WithConcurrencyNamespace stores the leases of the limiter under the provided
+// namespace, like WithNamespace does for a RateLimiter.
+func WithConcurrencyNamespace(namespace string) ConcurrencyOption {
+	return func(c *ConcurrencyLimiter) {
+		c.namespace = namespace
+	}
+}
+
+// WithConcurrencyClock sets the clock used to expire leases and to extend them
+// in KeepAlive. This can be replaced with a mock clock object for testing. By
+// default, leases expire according to the clock of the Redis server, so that
+// instances with skewed clocks agree on which leases expired.
+func WithConcurrencyClock(clock clock.Clock) ConcurrencyOption {
+	return func(c *ConcurrencyLimiter) {
+		c.clock = clock
+		c.localTime = true
+	}
+}
+
+// NewConcurrencyLimiter creates a limiter that allows max leases per id at the
+// same time. Leases expire after ttl unless they are extended.
+//
+// It panics if max is not positive or ttl is shorter than a millisecond, which
+// is the precision of expirations in Redis.
+func NewConcurrencyLimiter(
+	client *redis.Client,
+	max int64,
+	ttl time.Duration,
+	options ...ConcurrencyOption,
+) *ConcurrencyLimiter {
+	if max <= 0 {
+		panic(fmt.Sprintf("speedbump: invalid concurrency max %d", max))
+	}
+
+	if ttl < time.Millisecond {
+		panic(fmt.Sprintf("speedbump: invalid concurrency ttl %s, expected at least 1ms", ttl))
+	}
+
+	limiter := &ConcurrencyLimiter{
+		redisClient: client,
+		max:         max,
+		ttl:         ttl,
+		clock:       clock.New(),
+	}
+
+	for _, option := range options {
+		option(limiter)
+	}
+
+	return limiter
+}
+
+// Max returns the maximum number of leases per id.
+func (c *ConcurrencyLimiter) Max() int64 {
+	return c.max
+}
+
+// TTL returns how long leases last unless they are extended.
+func (c *ConcurrencyLimiter) TTL() time.Duration {
+	return c.ttl
+}
+
+// key generates the key of the leases of an id.
+func (c *ConcurrencyLimiter) key(id string) string {
+	if c.namespace == "" {
+		return "concurrency:" + id
+	}
+
+	return c.namespace + ":concurrency:" + id
+}
+
+// now returns the current time in milliseconds as an argument of the lease
+// scripts, which is empty when they should use the time of the server.
+func (c *ConcurrencyLimiter) now() string {
+	if !c.localTime {
+		return ""
+	}
+
+	return strconv.FormatInt(c.clock.Now().UnixNano()/int64(time.Millisecond), 10)
+}
+
+// Acquire takes one of the slots of an id. If all the slots are taken, it
+// returns false and a nil lease.
+func (c *ConcurrencyLimiter) Acquire(id string) (*Lease, bool, error) {
+	token, err := newToken()
+	if err != nil {
+		return nil, false, err
+	}
+
+	acquired, err := scriptFlag(acquireScript.Run(
+		c.redisClient,
+		[]string{c.key(id)},
+		c.now(), milliseconds(c.ttl), c.max, token,
+	))
+	if err != nil || !acquired {
+		return nil, false, err
+	}
+
+	return &Lease{limiter: c, id: id, token: token}, true, nil
+}
+
+// InFlight returns the number of leases of an id that have not expired.
+func (c *ConcurrencyLimiter) InFlight(id string) (int64, error) {
+	now := c.now()
+	if now == "" {
+		server, err := c.redisClient.Time().Result()
+		if err != nil {
+			return 0, err
+		}
+
+		now = strconv.FormatInt(server.UnixNano()/int64(time.Millisecond), 10)
+	}
+
+	return c.redisClient.ZCount(c.key(id), "("+now, "+inf").Result()
+}
+
+// Lease is a slot of a ConcurrencyLimiter held by a request.
+type Lease struct {
+	limiter *ConcurrencyLimiter
+	id      string
+	token   string
+}
+
+// ID returns the id the lease belongs to.
+func (l *Lease) ID() string {
+	return l.id
+}
+
+// Release frees the slot held by the lease. Releasing a lease more than once,
+// or after it expired, has no effect.
+func (l *Lease) Release() error {
+	return l.limiter.redisClient.ZRem(l.limiter.key(l.id), l.token).Err()
+}
+
+// Extend renews the lease for another TTL. If the lease already expired,
+// ErrLeaseExpired is returned.
+func (l *Lease) Extend() error {
+	extended, err := scriptFlag(extendScript.Run(
+		l.limiter.redisClient,
+		[]string{l.limiter.key(l.id)},
+		l.limiter.now(), milliseconds(l.limiter.ttl), l.token,
+	))
+	if err != nil {
+		return err
+	}
+
+	if !extended {
+		return ErrLeaseExpired
+	}
+
+	return nil
+}
+
+// KeepAlive extends the lease every interval until the returned function is
+// called, so that requests that take longer than the TTL keep their slot.
+// Errors are ignored, since the lease just expires if it can't be extended.
+func (l *Lease) KeepAlive(interval time.Duration) (stop func()) {
+	done := make(chan struct{})
+	ticker := l.limiter.clock.Ticker(interval)
+
+	go func() {
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				l.Extend()
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+	}
+}
+
+// scriptFlag converts the reply of a script that returns 0 or 1.
+func scriptFlag(cmd *redis.Cmd) (bool, error) {
+	reply, err := cmd.Result()
+	if err != nil {
+		return false, err
+	}
+
+	value, ok := reply.(int64)
+	if !ok {
+		return false, fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+	}
+
+	return value == 1, nil
+}
+
+// newToken generates a random token that identifies a lease.
+func newToken() (string, error) {
+	token := make([]byte, 16)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,139 @@
+package speedbump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 leases per id.
+	limiter := NewConcurrencyLimiter(client, 2, time.Minute, WithConcurrencyNamespace("exports"))
+
+	first, ok, err := limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "test_id", first.ID())
+
+	_, ok, err = limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	lease, ok, err := limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Nil(t, lease)
+
+	// Other ids have their own slots.
+	_, ok, err = limiter.Acquire("other_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	inFlight, err := limiter.InFlight("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), inFlight)
+
+	exists, err := client.Exists("exports:concurrency:test_id").Result()
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	// Releasing a lease frees its slot, and releasing it again has no effect.
+	require.NoError(t, first.Release())
+	require.NoError(t, first.Release())
+
+	_, ok, err = limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestConcurrencyLimiterExpiration(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	mock := clock.NewMock()
+	mock.Add(time.Hour)
+	limiter := NewConcurrencyLimiter(client, 1, time.Minute, WithConcurrencyClock(mock))
+
+	crashed, ok, err := limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Leases are kept for as long as they are extended.
+	mock.Add(50 * time.Second)
+	require.NoError(t, crashed.Extend())
+	mock.Add(50 * time.Second)
+
+	_, ok, err = limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Slots of leases that are never released are freed once they expire.
+	mock.Add(11 * time.Second)
+
+	inFlight, err := limiter.InFlight("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, inFlight)
+
+	lease, ok, err := limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, ErrLeaseExpired, crashed.Extend())
+	require.NoError(t, lease.Extend())
+}
+
+func TestConcurrencyLimiterServerTime(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	limiter := NewConcurrencyLimiter(client, 1, 100*time.Millisecond)
+
+	start := time.Now()
+	_, ok, err := limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	// Leases expire according to the clock of the Redis server, not the one of
+	// the instance that acquired them.
+	scores, err := client.ZRangeWithScores("concurrency:test_id", 0, -1).Result()
+	require.NoError(t, err)
+	require.Len(t, scores, 1)
+
+	expiry := time.Unix(0, int64(scores[0].Score)*int64(time.Millisecond))
+	assert.WithinDuration(t, start.Add(100*time.Millisecond), expiry, time.Second)
+
+	inFlight, err := limiter.InFlight("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), inFlight)
+
+	time.Sleep(150 * time.Millisecond)
+
+	inFlight, err = limiter.InFlight("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, inFlight)
+
+	lease, ok, err := limiter.Acquire("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	require.NoError(t, lease.Extend())
+}
+
+func TestNewConcurrencyLimiterInvalid(t *testing.T) {
+	client := createClient()
+
+	assert.Panics(t, func() { NewConcurrencyLimiter(client, 0, time.Minute) })
+	assert.Panics(t, func() { NewConcurrencyLimiter(client, 1, 0) })
+	assert.Panics(t, func() { NewConcurrencyLimiter(client, 1, time.Microsecond) })
+}
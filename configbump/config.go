@@ -0,0 +1,540 @@
+// Package configbump loads rate limit policies from YAML or JSON files, so
+// that limits can be tuned without changing code.
+//
+// A configuration file declares a list of named policies:
+//
+//	policies:
+//	  - name: login
+//	    period: minute
+//	    max: 5
+//	    methods: [POST]
+//	    routes: ["/login"]
+//	    failure_mode: closed
+//	  - name: api
+//	    window: 10s
+//	    max: 50
+//	    key: header:X-Api-Key
+//	    paths: ["/api/**"]
+//	    failure_mode: open
+//
+// Loading it results in an httpbump.PolicyTable, which can be used with the
+// middleware of httpbump and ginbump:
+//
+//	config, err := configbump.Load("ratelimits.yaml")
+//	if err != nil {
+//	  log.Fatal(err)
+//	}
+//
+//	table, err := config.Table(client)
+//	if err != nil {
+//	  log.Fatal(err)
+//	}
+//
+//	router.Use(ginbump.RateLimitPolicies(table))
+package configbump
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/httpbump"
+	"gopkg.in/redis.v5"
+	"gopkg.in/yaml.v3"
+)
+
+// Algorithms are the names of the supported rate limiting algorithms.
+var Algorithms = []string{"fixed_window"}
+
+// Config is a set of rate limit policies.
+type Config struct {
+	// Policies are the policies, in the order they are matched.
+	Policies []PolicyConfig `json:"policies" yaml:"policies"`
+
+	// lines maps fields to the line they were declared on, for files parsed
+	// from YAML.
+	lines map[string]int
+}
+
+// PolicyConfig declares a single policy. See httpbump.Policy for how policies
+// are matched against requests.
+type PolicyConfig struct {
+	// Name identifies the policy and namespaces its counters.
+	Name string `json:"name" yaml:"name"`
+	// Algorithm is the rate limiting algorithm. It defaults to
+	// "fixed_window", which is currently the only one.
+	Algorithm string `json:"algorithm,omitempty" yaml:"algorithm,omitempty"`
+	// Period is the period of a built-in hasher: "second", "minute" or "hour".
+	Period string `json:"period,omitempty" yaml:"period,omitempty"`
+	// Window is the duration of each period, such as "10s" or "15m", for
+	// periods that don't match a built-in hasher. Exactly one of Period and
+	// Window must be set.
+	Window string `json:"window,omitempty" yaml:"window,omitempty"`
+	// Max is the maximum number of requests allowed during a period.
+	Max int64 `json:"max" yaml:"max"`
+	// Key determines the id of the client:
+	//
+	//   - "ip" (the default) uses the IP address of the client.
+	//   - "header:<name>" uses the value of a request header.
+	//   - "query:<name>" uses the value of a query parameter.
+	//   - Any other name refers to a key function registered with WithKey.
+	//
+	// Requests without the header or query parameter are limited by IP
+	// address instead.
+	Key string `json:"key,omitempty" yaml:"key,omitempty"`
+	// Routes are the route patterns the policy applies to.
+	Routes []string `json:"routes,omitempty" yaml:"routes,omitempty"`
+	// Paths are the path patterns the policy applies to.
+	Paths []string `json:"paths,omitempty" yaml:"paths,omitempty"`
+	// Methods are the HTTP methods the policy applies to.
+	Methods []string `json:"methods,omitempty" yaml:"methods,omitempty"`
+	// FailureMode is what happens when the Redis server fails: "error" (the
+	// default), "open" or "closed". See speedbump.FailureMode.
+	FailureMode string `json:"failure_mode,omitempty" yaml:"failure_mode,omitempty"`
+	// Shadow reports requests over the limit without rejecting them, to find
+	// out who a new policy would affect. See speedbump.WithShadowMode.
+	Shadow bool `json:"shadow,omitempty" yaml:"shadow,omitempty"`
+	// ShadowHeader is a response header set on requests that were only allowed
+	// because of shadow mode.
+	ShadowHeader string `json:"shadow_header,omitempty" yaml:"shadow_header,omitempty"`
+}
+
+// FieldError is a problem with a single field of a configuration.
+type FieldError struct {
+	// Field is the path of the field, such as "policies[1].max".
+	Field string
+	// Line is the line the field was declared on, or zero if it is unknown.
+	Line int
+	// Message describes the problem.
+	Message string
+}
+
+// Error implements error.
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s: %s", e.Line, e.Field, e.Message)
+	}
+
+	return e.Field + ": " + e.Message
+}
+
+// ValidationError lists every problem found in a configuration.
+type ValidationError []*FieldError
+
+// Error implements error.
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+
+	return "configbump: invalid configuration:\n  " + strings.Join(messages, "\n  ")
+}
+
+// Load reads a configuration from a file. Files with a .json extension are
+// parsed as JSON, and any other file as YAML.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return ParseJSON(data)
+	}
+
+	return ParseYAML(data)
+}
+
+// ParseJSON parses and validates a JSON configuration. Unknown fields are
+// rejected, so that typos don't go unnoticed.
+func ParseJSON(data []byte) (*Config, error) {
+	config := &Config{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("configbump: %v", err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// ParseYAML parses and validates a YAML configuration. Unknown fields are
+// rejected, so that typos don't go unnoticed.
+func ParseYAML(data []byte) (*Config, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("configbump: %v", err)
+	}
+
+	config := &Config{lines: map[string]int{}}
+
+	if len(root.Content) > 0 {
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+
+		if err := decoder.Decode(config); err != nil {
+			return nil, fmt.Errorf("configbump: %v", err)
+		}
+
+		collectLines(root.Content[0], "", config.lines)
+	}
+
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// collectLines records the line of every field under a YAML node. Fields of
+// mappings are recorded at the line of their key.
+func collectLines(node *yaml.Node, path string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key := node.Content[i].Value
+			if path != "" {
+				key = path + "." + key
+			}
+
+			lines[key] = node.Content[i].Line
+			collectLines(node.Content[i+1], key, lines)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			key := fmt.Sprintf("%s[%d]", path, i)
+
+			lines[key] = item.Line
+			collectLines(item, key, lines)
+		}
+	}
+}
+
+// Validate checks the configuration. It returns a ValidationError with every
+// problem found.
+func (c *Config) Validate() error {
+	var errs ValidationError
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, c.fieldError(field, fmt.Sprintf(format, args...)))
+	}
+
+	if len(c.Policies) == 0 {
+		fail("policies", "at least one policy is required")
+	}
+
+	names := map[string]bool{}
+
+	for i, policy := range c.Policies {
+		field := func(name string) string {
+			return fmt.Sprintf("policies[%d].%s", i, name)
+		}
+
+		switch {
+		case policy.Name == "":
+			fail(field("name"), "is required")
+		case names[policy.Name]:
+			fail(field("name"), "duplicate policy name %q", policy.Name)
+		}
+
+		names[policy.Name] = true
+
+		if policy.Algorithm != "" && !contains(Algorithms, policy.Algorithm) {
+			fail(field("algorithm"), "unknown algorithm %q, expected one of: %s",
+				policy.Algorithm, strings.Join(Algorithms, ", "))
+		}
+
+		policy.limits().validate(fail, field)
+
+		if err := validateKey(policy.Key); err != "" {
+			fail(field("key"), "%s", err)
+		}
+
+		for j, method := range policy.Methods {
+			if method == "" || strings.ToUpper(method) != method {
+				fail(fmt.Sprintf("policies[%d].methods[%d]", i, j), "invalid method %q, expected an uppercase HTTP method", method)
+			}
+		}
+
+		if _, err := parseFailureMode(policy.FailureMode); err != nil {
+			fail(field("failure_mode"), "%v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// validate checks a period, window and max, reporting problems with fail.
+func (l Limits) validate(fail func(field, format string, args ...interface{}), field func(name string) string) {
+	switch {
+	case l.Period == "" && l.Window == "":
+		fail(field("period"), "either period or window is required")
+	case l.Period != "" && l.Window != "":
+		fail(field("window"), "cannot be used together with period")
+	case l.Period != "":
+		if _, err := speedbump.ParseHasher(l.Period); err != nil {
+			fail(field("period"), "unknown period %q, expected second, minute or hour", l.Period)
+		}
+	default:
+		window, err := time.ParseDuration(l.Window)
+		if err != nil || window <= 0 {
+			fail(field("window"), "invalid duration %q, expected a positive duration such as \"10s\"", l.Window)
+		} else if window < time.Millisecond {
+			fail(field("window"), "duration %q is too short, expected at least 1ms", l.Window)
+		}
+	}
+
+	if l.Max < 1 {
+		fail(field("max"), "must be at least 1")
+	}
+}
+
+// fieldError creates an error for a field, including its line if it is known.
+// If the field itself was not declared, the line of its parent is used.
+func (c *Config) fieldError(field, message string) *FieldError {
+	err := &FieldError{Field: field, Message: message}
+
+	for path := field; path != ""; {
+		if line, ok := c.lines[path]; ok {
+			err.Line = line
+			break
+		}
+
+		i := strings.LastIndexAny(path, ".[")
+		if i < 0 {
+			break
+		}
+
+		path = path[:i]
+	}
+
+	return err
+}
+
+// validateKey checks the syntax of a key. Names of custom keys can only be
+// checked when the table is built.
+func validateKey(key string) string {
+	kind, name, ok := strings.Cut(key, ":")
+	if !ok {
+		return ""
+	}
+
+	switch kind {
+	case "header", "query":
+		if name == "" {
+			return fmt.Sprintf("%s key requires a name, such as \"%s:X-Api-Key\"", kind, kind)
+		}
+
+		return ""
+	default:
+		return fmt.Sprintf("unknown key type %q, expected header or query", kind)
+	}
+}
+
+// parseFailureMode converts the name of a failure mode.
+func parseFailureMode(mode string) (speedbump.FailureMode, error) {
+	switch mode {
+	case "", "error":
+		return speedbump.FailError, nil
+	case "open":
+		return speedbump.FailOpen, nil
+	case "closed":
+		return speedbump.FailClosed, nil
+	default:
+		return 0, fmt.Errorf("unknown failure mode %q, expected error, open or closed", mode)
+	}
+}
+
+// Option configures how a table is built from a configuration.
+type Option func(*builder)
+
+// builder holds the options used to build a table.
+type builder struct {
+	resolver *httpbump.Resolver
+	keys     map[string]func(*http.Request) string
+	options  []speedbump.Option
+}
+
+// WithResolver determines the IP address of clients with the provided
+// resolver, for policies limited by IP address. IPv6 addresses are aggregated
+// by the prefix length of the resolver. See httpbump.Resolver.WithIPv6Prefix.
+func WithResolver(resolver *httpbump.Resolver) Option {
+	return func(b *builder) {
+		b.resolver = resolver
+	}
+}
+
+// WithKey registers a key function under a name, so that policies can use it
+// with "key: <name>".
+func WithKey(name string, key func(r *http.Request) string) Option {
+	return func(b *builder) {
+		b.keys[name] = key
+	}
+}
+
+// WithLimiterOptions passes options to the limiters of every policy, such as
+// speedbump.WithMetrics. They are applied before the options derived from the
+// configuration.
+func WithLimiterOptions(options ...speedbump.Option) Option {
+	return func(b *builder) {
+		b.options = append(b.options, options...)
+	}
+}
+
+// Table builds a table of policies from the configuration. The limits of the
+// policies can be changed at runtime through a Store.
+func (c *Config) Table(client *redis.Client, options ...Option) (*httpbump.PolicyTable, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	b := &builder{resolver: &httpbump.Resolver{}, keys: map[string]func(*http.Request) string{}}
+	for _, option := range options {
+		option(b)
+	}
+
+	var errs ValidationError
+	policies := []httpbump.Policy{}
+
+	for i, config := range c.Policies {
+		key, ok := b.key(config.Key)
+		if !ok {
+			errs = append(errs, c.fieldError(
+				fmt.Sprintf("policies[%d].key", i),
+				fmt.Sprintf("unknown key %q, expected ip, header:<name>, query:<name> or a registered key", config.Key),
+			))
+			continue
+		}
+
+		mode, _ := parseFailureMode(config.FailureMode)
+
+		policies = append(policies, httpbump.Policy{
+			Name:         config.Name,
+			Shadow:       config.Shadow,
+			ShadowHeader: config.ShadowHeader,
+			Routes:       config.Routes,
+			Paths:        config.Paths,
+			Methods:      config.Methods,
+			Hasher:       config.limits().Hasher(),
+			Max:          config.Max,
+			Key:          key,
+			Options: append(
+				append([]speedbump.Option{}, b.options...),
+				speedbump.WithName(config.Name),
+				speedbump.WithFailureMode(mode),
+				speedbump.WithUpdates(),
+			),
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+
+	return httpbump.NewPolicyTable(client, policies...)
+}
+
+// key returns the key function of a policy.
+func (b *builder) key(key string) (func(*http.Request) string, bool) {
+	ip := func(r *http.Request) string {
+		return b.resolver.Key(r)
+	}
+
+	kind, name, _ := strings.Cut(key, ":")
+
+	switch kind {
+	case "", "ip":
+		return ip, true
+	case "header":
+		return func(r *http.Request) string {
+			if value := r.Header.Get(name); value != "" {
+				return "header:" + value
+			}
+
+			return ip(r)
+		}, true
+	case "query":
+		return func(r *http.Request) string {
+			if value := r.URL.Query().Get(name); value != "" {
+				return "query:" + value
+			}
+
+			return ip(r)
+		}, true
+	}
+
+	custom, ok := b.keys[key]
+
+	return custom, ok
+}
+
+// limits returns the period, window and max of a policy.
+func (p *PolicyConfig) limits() Limits {
+	return Limits{Period: p.Period, Window: p.Window, Max: p.Max}
+}
+
+// Limits are the values of a policy that can be changed at runtime. See Store.
+type Limits struct {
+	// Period is the period of a built-in hasher. See PolicyConfig.Period.
+	Period string `json:"period,omitempty"`
+	// Window is the duration of each period. See PolicyConfig.Window.
+	Window string `json:"window,omitempty"`
+	// Max is the maximum number of requests allowed during a period.
+	Max int64 `json:"max"`
+}
+
+// Validate checks the limits. It returns a ValidationError with every problem
+// found.
+func (l Limits) Validate() error {
+	var errs ValidationError
+	l.validate(func(field, format string, args ...interface{}) {
+		errs = append(errs, &FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}, func(name string) string {
+		return name
+	})
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Hasher creates the hasher of valid limits.
+func (l Limits) Hasher() speedbump.RateHasher {
+	if l.Period != "" {
+		hasher, _ := speedbump.ParseHasher(l.Period)
+		return hasher
+	}
+
+	window, _ := time.ParseDuration(l.Window)
+
+	return speedbump.WindowHasher{Window: window}
+}
+
+// contains returns whether a list contains a value.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,227 @@
+package configbump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/redis.v5"
+)
+
+func createClient() *redis.Client {
+	if os.Getenv("WERCKER_REDIS_HOST") != "" {
+		return redis.NewClient(&redis.Options{
+			Addr:     os.Getenv("WERCKER_REDIS_HOST") + ":6379",
+			Password: "",
+			DB:       0,
+		})
+	}
+
+	return redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "",
+		DB:       0,
+	})
+}
+
+func teardown(t *testing.T, client *redis.Client) {
+	// Flush Redis.
+	require.NoError(t, client.FlushAll().Err())
+}
+
+const validYAML = `
+policies:
+  - name: login
+    period: minute
+    max: 5
+    methods: [POST]
+    routes: ["/login"]
+    failure_mode: closed
+  - name: api
+    window: 10s
+    max: 2
+    key: header:X-Api-Key
+    paths: ["/api/**"]
+    shadow: true
+    shadow_header: X-RateLimit-Shadow
+`
+
+func TestParseYAML(t *testing.T) {
+	config, err := ParseYAML([]byte(validYAML))
+	require.NoError(t, err)
+
+	assert.Equal(t, []PolicyConfig{
+		{
+			Name:        "login",
+			Period:      "minute",
+			Max:         5,
+			Methods:     []string{"POST"},
+			Routes:      []string{"/login"},
+			FailureMode: "closed",
+		},
+		{
+			Name:         "api",
+			Window:       "10s",
+			Max:          2,
+			Key:          "header:X-Api-Key",
+			Paths:        []string{"/api/**"},
+			Shadow:       true,
+			ShadowHeader: "X-RateLimit-Shadow",
+		},
+	}, config.Policies)
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	_, err := ParseYAML([]byte(`
+policies:
+  - name: login
+    period: fortnight
+    max: 0
+    failure_mode: sideways
+  - name: login
+    period: minute
+    window: 1m
+    max: 1
+    key: cookie:session
+    methods: [get]
+    algorithm: leaky_bucket
+  - max: 3
+`))
+	require.Error(t, err)
+
+	errs, ok := err.(ValidationError)
+	require.True(t, ok)
+
+	assert.Equal(t, []string{
+		`line 4: policies[0].period: unknown period "fortnight", expected second, minute or hour`,
+		`line 5: policies[0].max: must be at least 1`,
+		`line 6: policies[0].failure_mode: unknown failure mode "sideways", expected error, open or closed`,
+		`line 7: policies[1].name: duplicate policy name "login"`,
+		`line 13: policies[1].algorithm: unknown algorithm "leaky_bucket", expected one of: fixed_window`,
+		`line 9: policies[1].window: cannot be used together with period`,
+		`line 11: policies[1].key: unknown key type "cookie", expected header or query`,
+		`line 12: policies[1].methods[0]: invalid method "get", expected an uppercase HTTP method`,
+		`line 14: policies[2].name: is required`,
+		`line 14: policies[2].period: either period or window is required`,
+	}, errorStrings(errs))
+
+	_, err = ParseYAML([]byte("policies:\n  - name: login\n    window: 500us\n    max: 1\n"))
+	assert.EqualError(t, err, "configbump: invalid configuration:\n  line 3: policies[0].window: duration \"500us\" is too short, expected at least 1ms")
+
+	_, err = ParseYAML([]byte("policies:\n  - name: login\n    perod: minute\n"))
+	assert.EqualError(t, err, "configbump: yaml: unmarshal errors:\n  line 3: field perod not found in type configbump.PolicyConfig")
+
+	_, err = ParseYAML([]byte(""))
+	assert.EqualError(t, err, "configbump: invalid configuration:\n  policies: at least one policy is required")
+}
+
+func errorStrings(errs ValidationError) []string {
+	messages := []string{}
+	for _, err := range errs {
+		messages = append(messages, err.Error())
+	}
+
+	return messages
+}
+
+func TestParseJSON(t *testing.T) {
+	config, err := ParseJSON([]byte(`{"policies": [{"name": "login", "period": "hour", "max": 10}]}`))
+	require.NoError(t, err)
+	assert.Equal(t, []PolicyConfig{{Name: "login", Period: "hour", Max: 10}}, config.Policies)
+
+	_, err = ParseJSON([]byte(`{"policies": [{"name": "login", "period": "hour", "max": 0}]}`))
+	assert.EqualError(t, err, "configbump: invalid configuration:\n  policies[0].max: must be at least 1")
+
+	_, err = ParseJSON([]byte(`{"policies": [{"name": "login", "perod": "hour"}]}`))
+	assert.EqualError(t, err, `configbump: json: unknown field "perod"`)
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	yamlPath := filepath.Join(dir, "limits.yaml")
+	require.NoError(t, os.WriteFile(yamlPath, []byte(validYAML), 0o600))
+
+	config, err := Load(yamlPath)
+	require.NoError(t, err)
+	assert.Len(t, config.Policies, 2)
+
+	jsonPath := filepath.Join(dir, "limits.json")
+	require.NoError(t, os.WriteFile(jsonPath, []byte(`{"policies": [{"name": "a", "period": "second", "max": 1}]}`), 0o600))
+
+	config, err = Load(jsonPath)
+	require.NoError(t, err)
+	assert.Len(t, config.Policies, 1)
+
+	_, err = Load(filepath.Join(dir, "missing.yaml"))
+	assert.Error(t, err)
+}
+
+func TestTable(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	config, err := ParseYAML([]byte(validYAML))
+	require.NoError(t, err)
+
+	table, err := config.Table(client)
+	require.NoError(t, err)
+
+	login := table.Limiter("login")
+	require.NotNil(t, login)
+	assert.Equal(t, int64(5), login.Max())
+	assert.Equal(t, "login", login.Name())
+	assert.Equal(t, speedbump.PerMinuteHasher{}, login.Hasher())
+
+	api := table.Limiter("api")
+	require.NotNil(t, api)
+	assert.Equal(t, speedbump.WindowHasher{Window: 10 * time.Second}, api.Hasher())
+	assert.True(t, api.Shadow())
+	assert.False(t, login.Shadow())
+
+	r := httptest.NewRequest("GET", "/api/users", nil)
+	r.RemoteAddr = "1.2.3.4:1234"
+
+	policy, _ := table.Match(r, "")
+	require.NotNil(t, policy)
+	assert.Equal(t, "api", policy.Name)
+	assert.Equal(t, "1.2.3.4", policy.ID(r))
+
+	r.Header.Set("X-Api-Key", "abc")
+	assert.Equal(t, "header:abc", policy.ID(r))
+
+	r = httptest.NewRequest("POST", "/login", nil)
+	policy, _ = table.Match(r, "/login")
+	require.NotNil(t, policy)
+	assert.Equal(t, "login", policy.Name)
+}
+
+func TestTableCustomKey(t *testing.T) {
+	client := createClient()
+	defer teardown(t, client)
+
+	config, err := ParseYAML([]byte("policies:\n  - name: users\n    period: hour\n    max: 100\n    key: user\n"))
+	require.NoError(t, err)
+
+	_, err = config.Table(client)
+	assert.EqualError(t, err, "configbump: invalid configuration:\n  line 5: policies[0].key: unknown key \"user\", expected ip, header:<name>, query:<name> or a registered key")
+
+	table, err := config.Table(client, WithKey("user", func(r *http.Request) string {
+		return r.Header.Get("X-User")
+	}))
+	require.NoError(t, err)
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("X-User", "alice")
+
+	policy, _ := table.Match(r, "")
+	require.NotNil(t, policy)
+	assert.Equal(t, "alice", policy.ID(r))
+}
@@ -0,0 +1,479 @@
+package configbump
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/httpbump"
+	"gopkg.in/redis.v5"
+)
+
+// HistorySize is the number of versions kept for every policy in a Store.
+const HistorySize = 100
+
+// DefaultResyncInterval is how often Watch reloads every policy, in case an
+// update was published while it was disconnected.
+const DefaultResyncInterval = 30 * time.Second
+
+// Version is a change to the limits of a policy in a Store.
+type Version struct {
+	Limits
+	// Version increases with every change to the policy, starting at 1.
+	Version int64 `json:"version"`
+	// Time is when the change was made.
+	Time time.Time `json:"time"`
+	// Comment describes the change, such as the reason or the author.
+	Comment string `json:"comment,omitempty"`
+}
+
+// Store keeps the limits of policies in Redis, so that they can be changed at
+// runtime on every instance of a service at once. Every change creates a new
+// version, and the last HistorySize versions of each policy are kept so that
+// changes can be rolled back.
+//
+// Instances keep their tables up to date with Watch or Poll. Policies without
+// any version in the store keep the limits they were built with.
+//
+//	store := configbump.NewStore(client, "myapp:ratelimits")
+//	go store.Watch(ctx, table)
+//
+//	// During an incident, from anywhere:
+//	store.Put("login", configbump.Limits{Period: "minute", Max: 2}, "incident #42")
+type Store struct {
+	// ResyncInterval is how often Watch reloads every policy. It defaults to
+	// DefaultResyncInterval.
+	ResyncInterval time.Duration
+	// OnError is called by Watch and Poll with the errors of policies that
+	// can't be synced, such as when their current version is corrupt, and
+	// with any other error of the store, in which case the policy is empty.
+	// Network errors are only retried. It defaults to logging the errors with
+	// slog.Default.
+	OnError func(policy string, err error)
+
+	client *redis.Client
+	prefix string
+}
+
+// NewStore creates a store that keeps policies under keys starting with the
+// provided prefix, which is also the name of the channel updates are
+// published to.
+func NewStore(client *redis.Client, prefix string) *Store {
+	return &Store{client: client, prefix: prefix}
+}
+
+// key generates the key of the history of a policy.
+func (s *Store) key(name string) string {
+	return s.prefix + ":" + name
+}
+
+// Put stores new limits for a policy and notifies every instance watching the
+// store. It returns the new version.
+func (s *Store) Put(name string, limits Limits, comment string) (Version, error) {
+	if err := limits.Validate(); err != nil {
+		return Version{}, err
+	}
+
+	key := s.key(name)
+
+	for {
+		var version Version
+
+		err := s.client.Watch(func(tx *redis.Tx) error {
+			// Only the number of the current version is needed, so a corrupt
+			// version can be replaced, such as by rolling it back.
+			current, err := lastVersion(tx.LRange(key, 0, -1))
+			if err != nil {
+				return err
+			}
+
+			version = Version{
+				Limits:  limits,
+				Version: current + 1,
+				Time:    time.Now().UTC(),
+				Comment: comment,
+			}
+
+			data, err := json.Marshal(version)
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.Pipelined(func(pipe *redis.Pipeline) error {
+				pipe.RPush(key, data)
+				pipe.LTrim(key, -HistorySize, -1)
+				pipe.Publish(s.prefix, name)
+
+				return nil
+			})
+
+			return err
+		}, key)
+
+		// Another version was stored concurrently, so try again on top of it.
+		if err == redis.TxFailedErr {
+			continue
+		}
+
+		return version, err
+	}
+}
+
+// Current returns the current version of a policy, and whether there is one.
+func (s *Store) Current(name string) (Version, bool, error) {
+	return parseVersion(s.client.LIndex(s.key(name), -1))
+}
+
+// History returns the versions of a policy, from oldest to newest.
+func (s *Store) History(name string) ([]Version, error) {
+	values, err := s.client.LRange(s.key(name), 0, -1).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Version, len(values))
+	for i, value := range values {
+		if err := json.Unmarshal([]byte(value), &versions[i]); err != nil {
+			return nil, fmt.Errorf("configbump: invalid version of policy %q: %v", name, err)
+		}
+	}
+
+	return versions, nil
+}
+
+// Rollback restores the limits of a previous version of a policy. The limits
+// are stored as a new version, so the rollback can be undone too. Other
+// versions may be corrupt, which is how a policy is usually recovered.
+func (s *Store) Rollback(name string, version int64) (Version, error) {
+	values, err := s.client.LRange(s.key(name), 0, -1).Result()
+	if err != nil {
+		return Version{}, err
+	}
+
+	for _, value := range values {
+		var v Version
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			continue
+		}
+
+		if v.Version == version {
+			return s.Put(name, v.Limits, fmt.Sprintf("rollback to version %d", version))
+		}
+	}
+
+	return Version{}, fmt.Errorf("configbump: policy %q has no version %d", name, version)
+}
+
+// PolicyError is an error syncing a single policy, such as when its current
+// version in the store is corrupt. The policy keeps its current limits.
+type PolicyError struct {
+	// Name is the name of the policy.
+	Name string
+	// Err is the cause of the error.
+	Err error
+}
+
+// Error implements error.
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("configbump: policy %q: %v", e.Name, e.Err)
+}
+
+// Unwrap returns the cause of the error.
+func (e *PolicyError) Unwrap() error {
+	return e.Err
+}
+
+// Sync applies the current version of every policy of the table that has one
+// in the store. The limiters of the table must have been created with
+// speedbump.WithUpdates, like the ones built by Config.Table.
+//
+// Policies that can't be synced don't prevent the others from being synced.
+// Their errors are returned as PolicyErrors, joined with errors.Join, once
+// every policy has been tried.
+func (s *Store) Sync(table *httpbump.PolicyTable) error {
+	policies := table.Policies()
+	cmds := make([]*redis.StringCmd, len(policies))
+
+	// Errors are checked for every command, so that an error reading one
+	// policy doesn't stop the others from being synced.
+	s.client.Pipelined(func(pipe *redis.Pipeline) error {
+		for i, policy := range policies {
+			cmds[i] = pipe.LIndex(s.key(policy.Name), -1)
+		}
+
+		return nil
+	})
+
+	var errs []error
+
+	for i, policy := range policies {
+		value, err := cmds[i].Result()
+		if err == redis.Nil {
+			continue
+		}
+
+		if err != nil {
+			// The Redis server can't be reached, so neither can the other
+			// policies.
+			if transient(err) {
+				return err
+			}
+
+			errs = append(errs, &PolicyError{Name: policy.Name, Err: err})
+			continue
+		}
+
+		version, err := decodeVersion(value)
+		if err == nil {
+			err = apply(table, policy.Name, version)
+		}
+
+		if err != nil {
+			errs = append(errs, &PolicyError{Name: policy.Name, Err: err})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Watch keeps the limits of a table up to date by subscribing to updates.
+// Every policy is also reloaded every ResyncInterval and after reconnecting to
+// the Redis server, so that updates missed while disconnected are applied.
+//
+// Policies that can't be synced keep their current limits, and their errors
+// are passed to OnError, while the other policies keep being updated. Watch
+// blocks until the context is done, in which case it returns nil, and only
+// returns earlier if it can't subscribe to updates.
+func (s *Store) Watch(ctx context.Context, table *httpbump.PolicyTable) error {
+	pubsub, err := s.client.Subscribe(s.prefix)
+	if err != nil {
+		return err
+	}
+
+	// Closing the subscription interrupts ReceiveTimeout when the context is
+	// done.
+	stop := make(chan struct{})
+	defer close(stop)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-stop:
+		}
+
+		pubsub.Close()
+	}()
+
+	// The table is synced after subscribing, so that updates published in
+	// between are not missed.
+	s.resync(ctx, table)
+
+	interval := s.ResyncInterval
+	if interval <= 0 {
+		interval = DefaultResyncInterval
+	}
+
+	for {
+		msg, err := pubsub.ReceiveTimeout(interval)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil {
+			s.resync(ctx, table)
+			continue
+		}
+
+		if msg, ok := msg.(*redis.Message); ok {
+			// Updates missed because of a network error are applied by the
+			// next resync.
+			s.report(s.reload(table, msg.Payload))
+		}
+	}
+}
+
+// Poll keeps the limits of a table up to date by syncing it every interval,
+// for deployments where pub/sub is not available. Like Watch, it blocks until
+// the context is done, and the errors of policies that can't be synced are
+// passed to OnError.
+func (s *Store) Poll(ctx context.Context, table *httpbump.PolicyTable, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		// Network errors are retried on the next tick.
+		s.report(s.Sync(table))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// resync syncs a table after subscribing, a timeout or an error. If the Redis
+// server is unreachable, it waits a second, so that Watch doesn't spin while
+// it is down.
+func (s *Store) resync(ctx context.Context, table *httpbump.PolicyTable) {
+	if err := s.report(s.Sync(table)); err == nil {
+		return
+	}
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+	}
+}
+
+// report passes the errors of Sync or reload to OnError, except for network
+// errors, which are returned so that the caller can retry.
+func (s *Store) report(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	errs := []error{err}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs = joined.Unwrap()
+	}
+
+	onError := s.OnError
+	if onError == nil {
+		onError = logError
+	}
+
+	var retry error
+	for _, err := range errs {
+		var policyErr *PolicyError
+
+		switch {
+		case errors.As(err, &policyErr):
+			onError(policyErr.Name, policyErr.Err)
+		case transient(err):
+			retry = err
+		default:
+			onError("", err)
+		}
+	}
+
+	return retry
+}
+
+// logError is the default OnError of a Store.
+func logError(policy string, err error) {
+	slog.Default().Error("configbump: can't sync policy", "policy", policy, "error", err)
+}
+
+// reload applies the current version of a single policy.
+func (s *Store) reload(table *httpbump.PolicyTable, name string) error {
+	value, err := s.client.LIndex(s.key(name), -1).Result()
+	if err == redis.Nil {
+		return nil
+	}
+
+	if err != nil {
+		if transient(err) {
+			return err
+		}
+
+		return &PolicyError{Name: name, Err: err}
+	}
+
+	version, err := decodeVersion(value)
+	if err == nil {
+		err = apply(table, name, version)
+	}
+
+	if err != nil {
+		return &PolicyError{Name: name, Err: err}
+	}
+
+	return nil
+}
+
+// apply updates the limiter of a policy, if the table has it.
+func apply(table *httpbump.PolicyTable, name string, version Version) error {
+	limiter := table.Limiter(name)
+	if limiter == nil {
+		return nil
+	}
+
+	return limiter.Update(version.Hasher(), version.Max)
+}
+
+// parseVersion decodes a version read from the store, and reports whether it
+// exists.
+func parseVersion(cmd *redis.StringCmd) (Version, bool, error) {
+	var version Version
+
+	value, err := cmd.Result()
+	if err == redis.Nil {
+		return version, false, nil
+	}
+
+	if err != nil {
+		return version, false, err
+	}
+
+	version, err = decodeVersion(value)
+	if err != nil {
+		return version, false, fmt.Errorf("configbump: %v", err)
+	}
+
+	return version, true, nil
+}
+
+// decodeVersion decodes and validates a version read from the store.
+func decodeVersion(value string) (Version, error) {
+	var version Version
+
+	if err := json.Unmarshal([]byte(value), &version); err != nil {
+		return version, fmt.Errorf("invalid version: %v", err)
+	}
+
+	if err := version.Validate(); err != nil {
+		return version, fmt.Errorf("invalid version: %v", err)
+	}
+
+	return version, nil
+}
+
+// lastVersion returns the highest version number in the history of a policy,
+// or 0 if it has none. Versions are not validated, and the ones that can't be
+// decoded are skipped, so that a corrupt version doesn't prevent storing new
+// ones.
+func lastVersion(cmd *redis.StringSliceCmd) (int64, error) {
+	values, err := cmd.Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var last int64
+	for _, value := range values {
+		var version struct {
+			Version int64 `json:"version"`
+		}
+
+		if err := json.Unmarshal([]byte(value), &version); err == nil && version.Version > last {
+			last = version.Version
+		}
+	}
+
+	return last, nil
+}
+
+// transient returns whether an error is caused by the network, in which case
+// the operation can be retried later.
+func transient(err error) bool {
+	switch speedbump.ErrorKind(err) {
+	case "timeout", "connection":
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,274 @@
+package configbump
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/httpbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createTable(t *testing.T) *httpbump.PolicyTable {
+	config, err := ParseYAML([]byte(validYAML))
+	require.NoError(t, err)
+
+	table, err := config.Table(createClient())
+	require.NoError(t, err)
+
+	return table
+}
+
+func TestStore(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	store := NewStore(client, "test:policies")
+
+	_, ok, err := store.Current("login")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	_, err = store.Put("login", Limits{Period: "minute"}, "")
+	assert.EqualError(t, err, "configbump: invalid configuration:\n  max: must be at least 1")
+
+	v1, err := store.Put("login", Limits{Period: "minute", Max: 2}, "incident")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), v1.Version)
+	assert.Equal(t, "incident", v1.Comment)
+
+	v2, err := store.Put("login", Limits{Window: "10s", Max: 20}, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), v2.Version)
+
+	current, ok, err := store.Current("login")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Limits{Window: "10s", Max: 20}, current.Limits)
+
+	v3, err := store.Rollback("login", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), v3.Version)
+	assert.Equal(t, Limits{Period: "minute", Max: 2}, v3.Limits)
+	assert.Equal(t, "rollback to version 1", v3.Comment)
+
+	_, err = store.Rollback("login", 7)
+	assert.EqualError(t, err, `configbump: policy "login" has no version 7`)
+
+	history, err := store.History("login")
+	require.NoError(t, err)
+	require.Len(t, history, 3)
+	assert.Equal(t, []int64{1, 2, 3}, []int64{history[0].Version, history[1].Version, history[2].Version})
+}
+
+func TestStoreHistorySize(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	store := NewStore(client, "test:policies")
+
+	for i := 1; i <= HistorySize+5; i++ {
+		_, err := store.Put("login", Limits{Period: "minute", Max: int64(i)}, "")
+		require.NoError(t, err)
+	}
+
+	history, err := store.History("login")
+	require.NoError(t, err)
+	require.Len(t, history, HistorySize)
+	assert.Equal(t, int64(6), history[0].Version)
+	assert.Equal(t, int64(HistorySize+5), history[HistorySize-1].Version)
+}
+
+func TestStoreCorruptVersion(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	store := NewStore(client, "test:policies")
+
+	_, err := store.Put("login", Limits{Period: "minute", Max: 2}, "")
+	require.NoError(t, err)
+
+	// Store versions that don't validate, or can't be decoded at all.
+	require.NoError(t, client.RPush("test:policies:login", `{"max":-1,"version":2}`).Err())
+
+	_, _, err = store.Current("login")
+	assert.Error(t, err)
+
+	v3, err := store.Rollback("login", 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), v3.Version)
+
+	require.NoError(t, client.RPush("test:policies:login", `not json`).Err())
+
+	v4, err := store.Put("login", Limits{Period: "minute", Max: 5}, "")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), v4.Version)
+
+	current, ok, err := store.Current("login")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, v4.Limits, current.Limits)
+}
+
+func TestStoreSync(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	store := NewStore(client, "test:policies")
+	table := createTable(t)
+
+	_, err := store.Put("api", Limits{Period: "hour", Max: 7}, "")
+	require.NoError(t, err)
+
+	// Versions of policies that are not in the table are ignored.
+	_, err = store.Put("unknown", Limits{Period: "hour", Max: 7}, "")
+	require.NoError(t, err)
+
+	require.NoError(t, store.Sync(table))
+
+	assert.Equal(t, int64(7), table.Limiter("api").Max())
+	assert.Equal(t, speedbump.PerHourHasher{}, table.Limiter("api").Hasher())
+	assert.Equal(t, int64(5), table.Limiter("login").Max())
+
+	// Tables whose limiters can't be updated are rejected.
+	static, err := httpbump.NewPolicyTable(client, httpbump.Policy{
+		Name:   "api",
+		Hasher: speedbump.PerMinuteHasher{},
+		Max:    1,
+	})
+	require.NoError(t, err)
+	assert.EqualError(t, store.Sync(static), `configbump: policy "api": `+speedbump.ErrNotUpdatable.Error())
+}
+
+func TestStoreWatch(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	store := NewStore(client, "test:policies")
+	table := createTable(t)
+
+	_, err := store.Put("login", Limits{Period: "minute", Max: 3}, "")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- store.Watch(ctx, table)
+	}()
+
+	// The current versions are applied when watching starts.
+	assert.Eventually(t, func() bool {
+		return table.Limiter("login").Max() == 3
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = store.Put("login", Limits{Period: "minute", Max: 1}, "")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return table.Limiter("login").Max() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not return after the context was canceled")
+	}
+}
+
+func TestStoreWatchCorruptVersion(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	store := NewStore(client, "test:policies")
+	table := createTable(t)
+
+	errs := make(chan string, 10)
+	store.OnError = func(policy string, err error) {
+		errs <- policy
+	}
+
+	// The current version of a policy is corrupt.
+	require.NoError(t, client.RPush("test:policies:login", `{"max":-1,"version":1}`).Err())
+
+	_, err := store.Put("api", Limits{Period: "hour", Max: 7}, "")
+	require.NoError(t, err)
+
+	// Other policies are still synced.
+	err = store.Sync(table)
+	var policyErr *PolicyError
+	require.ErrorAs(t, err, &policyErr)
+	assert.Equal(t, "login", policyErr.Name)
+	assert.Equal(t, int64(7), table.Limiter("api").Max())
+	assert.Equal(t, int64(5), table.Limiter("login").Max())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- store.Watch(ctx, table)
+	}()
+
+	assert.Equal(t, "login", <-errs)
+
+	// Updates of other policies, and of the corrupt one, keep being applied.
+	_, err = store.Put("api", Limits{Period: "hour", Max: 9}, "")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return table.Limiter("api").Max() == 9
+	}, time.Second, 10*time.Millisecond)
+
+	_, err = store.Put("login", Limits{Period: "minute", Max: 2}, "")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return table.Limiter("login").Max() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not return after the context was canceled")
+	}
+}
+
+func TestStorePoll(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	store := NewStore(client, "test:policies")
+	table := createTable(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+
+	go func() {
+		done <- store.Poll(ctx, table, 10*time.Millisecond)
+	}()
+
+	_, err := store.Put("api", Limits{Window: "1m", Max: 9}, "")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		return table.Limiter("api").Max() == 9
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
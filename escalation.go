@@ -0,0 +1,181 @@
+package speedbump
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// DefaultDecay is the decay of an Escalation that doesn't set one.
+const DefaultDecay = 24 * time.Hour
+
+// escalateScript records an offence of an id and bans it. KEYS has the
+// offences and ban keys of the id, and ARGV has the first ban, the factor, the
+// longest ban and the decay, with durations in milliseconds. It returns the
+// number of offences and the duration of the ban.
+//
+// Ids that are already banned are left alone, so that concurrent attempts that
+// went over the limit before the ban was set only count as one offence.
+var escalateScript = redis.NewScript(`
+local remaining = redis.call("PTTL", KEYS[2])
+if remaining ~= -2 then
+  return {tonumber(redis.call("GET", KEYS[1]) or 0), remaining}
+end
+
+local base = tonumber(ARGV[1])
+local factor = tonumber(ARGV[2])
+local longest = tonumber(ARGV[3])
+
+local offences = redis.call("INCR", KEYS[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[4])
+
+-- Bans without a limit are still capped, so that they don't overflow.
+if longest <= 0 then
+  longest = 2 ^ 50
+end
+
+local ban = math.floor(base * factor ^ (offences - 1))
+if ban > longest then
+  ban = longest
+end
+
+redis.call("SET", KEYS[2], "1", "PX", ban)
+
+return {offences, ban}
+`)
+
+// Escalation bans ids that keep exceeding the limit, fail2ban style. Every
+// time an attempt is denied because the id reached the limit, an offence is
+// recorded and the id is banned for longer than the previous time:
+//
+//	// Ban for 1 minute, then 10 minutes, then an hour.
+//	speedbump.WithEscalation(speedbump.Escalation{
+//	  Ban:    time.Minute,
+//	  Factor: 10,
+//	  MaxBan: time.Hour,
+//	  Decay:  24 * time.Hour,
+//	})
+//
+// Attempts denied because the id is banned are not offences, so an id only
+// escalates if it keeps going over the limit once its ban is lifted.
+type Escalation struct {
+	// Ban is how long an id is banned for after its first offence.
+	Ban time.Duration
+	// Factor is how much longer every ban is than the previous one. Factors
+	// lower than 1 are treated as 1, so every ban lasts Ban.
+	Factor float64
+	// MaxBan is the longest an id can be banned for. If it is zero, bans grow
+	// without limit.
+	MaxBan time.Duration
+	// Decay is how long an id has to go without offences before its count of
+	// offences is reset. It should be longer than MaxBan, otherwise the count
+	// may be reset while the id is banned. It defaults to DefaultDecay.
+	Decay time.Duration
+}
+
+// BanFor returns how long an id is banned for after the provided number of
+// offences.
+func (e Escalation) BanFor(offences int64) time.Duration {
+	if offences < 1 {
+		return 0
+	}
+
+	ban := float64(e.Ban)
+	for i := int64(1); i < offences && (e.MaxBan <= 0 || ban < float64(e.MaxBan)); i++ {
+		ban *= e.factor()
+	}
+
+	if e.MaxBan > 0 && ban > float64(e.MaxBan) {
+		return e.MaxBan
+	}
+
+	return time.Duration(ban)
+}
+
+// factor returns the factor of the escalation, or 1 if it is lower.
+func (e Escalation) factor() float64 {
+	if e.Factor < 1 {
+		return 1
+	}
+
+	return e.Factor
+}
+
+// decay returns the decay of the escalation, or DefaultDecay if it is not set.
+func (e Escalation) decay() time.Duration {
+	if e.Decay <= 0 {
+		return DefaultDecay
+	}
+
+	return e.Decay
+}
+
+// WithEscalation bans ids that keep exceeding the limit for increasingly longer
+// periods. See Escalation. Limiters in shadow mode don't ban ids.
+func WithEscalation(escalation Escalation) Option {
+	return func(r *RateLimiter) {
+		r.escalation = &escalation
+	}
+}
+
+// Offences returns the number of offences recorded for an id by the
+// escalation of the limiter, since they were last reset.
+func (r *RateLimiter) Offences(id string) (int64, error) {
+	offences, err := r.redisClient.Get(r.offencesKey(id)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+
+	return offences, err
+}
+
+// Forgive resets the offences of an id and lifts its ban, if there is one, so
+// that its next offence is treated as the first one.
+func (r *RateLimiter) Forgive(id string) error {
+	return r.redisClient.Del(r.offencesKey(id), r.banKey(id)).Err()
+}
+
+// escalate records an offence of an id and bans it, if the limiter has an
+// escalation.
+func (r *RateLimiter) escalate(id string) error {
+	if r.escalation == nil || r.shadow {
+		return nil
+	}
+
+	start := time.Now()
+	reply, err := escalateScript.Run(
+		r.redisClient,
+		[]string{r.offencesKey(id), r.banKey(id)},
+		milliseconds(r.escalation.Ban),
+		r.escalation.factor(),
+		milliseconds(r.escalation.MaxBan),
+		milliseconds(r.escalation.decay()),
+	).Result()
+	r.observeLatency("escalate", start)
+
+	if err != nil {
+		return err
+	}
+
+	if values, ok := reply.([]interface{}); !ok || len(values) != 2 {
+		return fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+	}
+
+	return nil
+}
+
+// offencesKey generates the key of the offences of an id.
+func (r *RateLimiter) offencesKey(id string) string {
+	if r.namespace == "" {
+		return "offences:" + id
+	}
+
+	return r.namespace + ":offences:" + id
+}
+
+// milliseconds converts a duration to milliseconds, which is the precision of
+// expirations in Redis.
+func milliseconds(d time.Duration) int64 {
+	return d.Nanoseconds() / int64(time.Millisecond)
+}
@@ -0,0 +1,220 @@
+package speedbump
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEscalation(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 1 request/hour that escalates bans.
+	limiter := NewLimiter(client, PerHourHasher{}, 1, WithEscalation(Escalation{
+		Ban:    time.Minute,
+		Factor: 10,
+		MaxBan: time.Hour,
+	}))
+
+	makeNAttempts(t, limiter, "test_id", 1)
+
+	offences, err := limiter.Offences("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, offences)
+
+	for i, expected := range []time.Duration{time.Minute, 10 * time.Minute, time.Hour} {
+		ok, err := limiter.Attempt("test_id")
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		// Attempts of banned ids are not offences.
+		ok, err = limiter.Attempt("test_id")
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		offences, err := limiter.Offences("test_id")
+		require.NoError(t, err)
+		assert.Equal(t, int64(i+1), offences)
+
+		banned, err := limiter.Banned("test_id")
+		require.NoError(t, err)
+		assert.InDelta(t, expected, banned, float64(time.Second))
+
+		// Lifting the ban keeps the offences, so the next one escalates.
+		require.NoError(t, limiter.Unban("test_id"))
+	}
+
+	// The count of offences expires after the decay.
+	ttl, err := client.PTTL("offences:test_id").Result()
+	require.NoError(t, err)
+	assert.InDelta(t, DefaultDecay, ttl, float64(time.Second))
+
+	require.NoError(t, limiter.Forgive("test_id"))
+
+	offences, err = limiter.Offences("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, offences)
+
+	banned, err := limiter.Banned("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+}
+
+func TestEscalationBatch(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	limiter := NewLimiter(client, PerHourHasher{}, 1, WithEscalation(Escalation{
+		Ban:   time.Minute,
+		Decay: time.Hour,
+	}))
+
+	ctx := context.Background()
+	ids := []string{"a", "b"}
+
+	_, err := limiter.AttemptMany(ctx, ids[:1], nil)
+	require.NoError(t, err)
+
+	decisions, err := limiter.AttemptMany(ctx, ids, nil)
+	require.NoError(t, err)
+	assert.False(t, decisions[0].Allowed)
+	assert.True(t, decisions[1].Allowed)
+
+	banned, err := limiter.Banned("a")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute, banned, float64(time.Second))
+
+	banned, err = limiter.Banned("b")
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+}
+
+func TestEscalationBatchCost(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	limiter := NewLimiter(client, PerHourHasher{}, 3, WithEscalation(Escalation{Ban: time.Minute}))
+
+	ctx := context.Background()
+
+	// An id denied only because its cost is larger than what it has left is
+	// still under the limit, so it is not an offence.
+	_, err := limiter.AttemptMany(ctx, []string{"test_id"}, []int64{2})
+	require.NoError(t, err)
+
+	decisions, err := limiter.AttemptMany(ctx, []string{"test_id"}, []int64{2})
+	require.NoError(t, err)
+	assert.False(t, decisions[0].Allowed)
+
+	offences, err := limiter.Offences("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, offences)
+
+	banned, err := limiter.Banned("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+}
+
+func TestEscalationBatchFailure(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	limiter := NewLimiter(
+		client, PerHourHasher{}, 1,
+		WithEscalation(Escalation{Ban: time.Minute}),
+		WithFailureMode(FailOpen),
+	)
+
+	ctx := context.Background()
+	makeNAttempts(t, limiter, "a", 1)
+
+	// Escalating "a" fails because its offences key has the wrong type.
+	require.NoError(t, client.HSet("offences:a", "field", "value").Err())
+
+	decisions, err := limiter.AttemptMany(ctx, []string{"a", "b", "c"}, nil)
+	require.NoError(t, err)
+
+	// Only the id that failed is decided by the failure mode, and the ids
+	// after it are still counted.
+	assert.True(t, decisions[0].Allowed)
+	assert.False(t, decisions[0].Refundable())
+	assert.True(t, decisions[1].Refundable())
+	assert.True(t, decisions[2].Refundable())
+
+	attempted, err := limiter.Attempted("c")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), attempted)
+}
+
+func TestEscalationConcurrent(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	limiter := NewLimiter(client, PerHourHasher{}, 1, WithEscalation(Escalation{
+		Ban:    time.Minute,
+		Factor: 10,
+	}))
+
+	makeNAttempts(t, limiter, "test_id", 1)
+
+	// A burst of attempts over the limit is a single offence, even if they all
+	// read the counter before the id was banned.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			_, err := limiter.Attempt("test_id")
+			assert.NoError(t, err)
+		}()
+	}
+
+	wg.Wait()
+
+	offences, err := limiter.Offences("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), offences)
+
+	banned, err := limiter.Banned("test_id")
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute, banned, float64(time.Second))
+}
+
+func TestEscalationShadow(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	limiter := NewLimiter(
+		client, PerHourHasher{}, 1,
+		WithEscalation(Escalation{Ban: time.Minute}),
+		WithShadowMode(),
+	)
+
+	makeNAttempts(t, limiter, "test_id", 3)
+
+	banned, err := limiter.Banned("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, banned)
+}
+
+func TestEscalationBanFor(t *testing.T) {
+	escalation := Escalation{Ban: time.Minute, Factor: 10, MaxBan: time.Hour}
+
+	assert.Zero(t, escalation.BanFor(0))
+	assert.Equal(t, time.Minute, escalation.BanFor(1))
+	assert.Equal(t, 10*time.Minute, escalation.BanFor(2))
+	assert.Equal(t, time.Hour, escalation.BanFor(3))
+	assert.Equal(t, time.Hour, escalation.BanFor(100))
+
+	// Without a factor, every ban lasts the same.
+	assert.Equal(t, time.Minute, Escalation{Ban: time.Minute}.BanFor(5))
+
+	// Without a max, bans keep growing.
+	assert.Equal(t, 8*time.Minute, Escalation{Ban: time.Minute, Factor: 2}.BanFor(4))
+}
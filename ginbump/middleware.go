@@ -3,11 +3,12 @@
 package ginbump
 
 import (
-	"net"
+	"net/http"
 	"time"
 
 	"github.com/dustin/go-humanize"
 	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/httpbump"
 	"github.com/gin-gonic/gin"
 	"gopkg.in/redis.v5"
 )
@@ -20,6 +21,17 @@ import (
 // when the client should be able to do requests again. The limit per period is
 // defined by the max.
 //
+// IPv6 clients are grouped by their network prefix. See httpbump.IPKey.
+//
+// Requests matched by any of the skippers are exempt from the limit and are
+// not counted:
+//
+//  router.Use(ginbump.RateLimit(
+//    client, hasher, 100,
+//    httpbump.SkipPaths("/health"),
+//    httpbump.SkipMethods("OPTIONS"),
+//  ))
+//
 // Response format
 //
 // Once a client reaches the imposed limit, they will receive a JSON response
@@ -29,70 +41,250 @@ import (
 //    "messages":["Rate limit exceeded. Try again in 1 minute from now"],
 //    "status":"error"
 //  }
-func RateLimit(client *redis.Client, hasher speedbump.RateHasher, max int64) gin.HandlerFunc {
-	limiter := speedbump.NewLimiter(client, hasher, max)
+func RateLimit(
+	client *redis.Client,
+	hasher speedbump.RateHasher,
+	max int64,
+	skippers ...httpbump.Skipper,
+) gin.HandlerFunc {
+	return RateLimitWithLimiter(speedbump.NewLimiter(client, hasher, max), nil, skippers...)
+}
+
+// RateLimitLB is very similar to RateLimit but it takes the X-Forwarded-For
+// header in cosideration when trying to figure the IP address of the client.
+// This is useful for when running a server behind a load balancer or proxy.
+//
+// However, this header can be spoofed by the client, so in some cases it could
+// provide a way of getting around the rate limiter.
+//
+// When using this middleware, make sure the load balancer will strip any
+// X-Forwarded-For headers set by the client, and that the server will not be
+// publicly accessible by the public, just the load balancer.
+func RateLimitLB(
+	client *redis.Client,
+	hasher speedbump.RateHasher,
+	max int64,
+	skippers ...httpbump.Skipper,
+) gin.HandlerFunc {
+	return RateLimitWithLimiter(speedbump.NewLimiter(client, hasher, max), forwardedKey, skippers...)
+}
+
+// RateLimitWithResolver is similar to RateLimitLB, but it uses the provided
+// resolver to determine the IP address of the client. The resolver only
+// believes X-Forwarded-For entries added by trusted proxies, which makes it
+// safe to use even when the server can be reached directly.
+//
+//  resolver, err := httpbump.NewResolver("10.0.0.0/8")
+//  if err != nil {
+//    panic(err)
+//  }
+//
+//  router.Use(ginbump.RateLimitWithResolver(client, hasher, 100, resolver))
+func RateLimitWithResolver(
+	client *redis.Client,
+	hasher speedbump.RateHasher,
+	max int64,
+	resolver *httpbump.Resolver,
+	skippers ...httpbump.Skipper,
+) gin.HandlerFunc {
+	return RateLimitWithLimiter(speedbump.NewLimiter(client, hasher, max), resolver.Key, skippers...)
+}
+
+// RateLimitWithLimiter is similar to RateLimit, but it uses an existing
+// limiter, which allows configuring it with any of the options accepted by
+// speedbump.NewLimiter, such as metrics, a failure mode, or a listener to log
+// rejected requests. Any speedbump.Limiter can be used, such as the fake in the
+// speedbumptest package.
+//
+// The key function determines the id of the client, which is used as is. If it
+// is nil, the address of the connecting peer is used, with IPv6 addresses
+// aggregated by httpbump.DefaultIPv6PrefixLength. Use the Key method of a
+// resolver to aggregate them by another prefix length, such as
+// resolver.WithIPv6Prefix(48).Key.
+//
+//  limiter := speedbump.NewLimiter(
+//    client, speedbump.PerMinuteHasher{}, 100,
+//    speedbump.WithMetrics(collector),
+//    speedbump.WithFailureMode(speedbump.FailOpen),
+//    speedbump.WithListener(speedbump.NewSlogListener(nil)),
+//  )
+//
+//  router.Use(ginbump.RateLimitWithLimiter(limiter, nil))
+func RateLimitWithLimiter(
+	limiter speedbump.Limiter,
+	key func(r *http.Request) string,
+	skippers ...httpbump.Skipper,
+) gin.HandlerFunc {
+	skip := httpbump.Skip(skippers...)
+
+	if key == nil {
+		key = peerKey
+	}
 
 	return func(c *gin.Context) {
+		// Let exempt requests through without counting them.
+		if skip(c.Request) {
+			c.Next()
+			return
+		}
+
 		// Attempt to perform the request
-		ip, _, _ := net.SplitHostPort(c.Request.RemoteAddr)
-		ok, err := limiter.Attempt(ip)
+		ctx := c.Request.Context()
+		decision, err := limiter.Decide(ctx, key(c.Request))
 
 		if err != nil {
 			panic(err)
 		}
 
-		if !ok {
-			nextTime := time.Now().Add(hasher.Duration())
+		speedbump.AddDecisionEvent(ctx, limiter, decision.Allowed)
 
-			c.JSON(429, gin.H{
-				"status":   "error",
-				"messages": []string{"Rate limit exceeded. Try again in " + humanize.Time(nextTime)},
-			})
-			c.Abort()
+		if !decision.Allowed {
+			// The hasher is read on every rejection, since the period of the
+			// limiter can be updated at runtime.
+			limited(c, limiter.Hasher())
 		}
 
 		c.Next()
+	}
+}
 
-		// After the request
-		// log.Print(ip + " was limited because it exceeded the max rate")
+// RateLimitPolicies is a Gin middleware that limits incoming requests
+// according to a table of policies, so that different routes can have
+// different limits without having to split them into separate groups.
+//
+// Policies are matched against the route pattern of the request, as returned
+// by gin.Context.FullPath (e.g. "/users/:id"). Requests that don't match any
+// policy, or that are matched by any of the skippers, are not limited.
+//
+//  table, err := httpbump.NewPolicyTable(client,
+//    httpbump.Policy{
+//      Name:    "login",
+//      Routes:  []string{"/login"},
+//      Methods: []string{"POST"},
+//      Hasher:  speedbump.PerMinuteHasher{},
+//      Max:     5,
+//    },
+//    httpbump.Policy{
+//      Name:   "search",
+//      Routes: []string{"/search"},
+//      Hasher: speedbump.PerMinuteHasher{},
+//      Max:    100,
+//    },
+//  )
+//
+//  router.Use(ginbump.RateLimitPolicies(table))
+//
+// Attempts of requests whose response status matches Policy.RefundWhen are
+// refunded once the rest of the chain returns. Policies with CountWhen only
+// count the requests it selects, once they are handled. Handlers can mark
+// requests with httpbump.MarkCounted(c.Request).
+func RateLimitPolicies(table *httpbump.PolicyTable, skippers ...httpbump.Skipper) gin.HandlerFunc {
+	skip := httpbump.Skip(skippers...)
+
+	return func(c *gin.Context) {
+		if skip(c.Request) {
+			c.Next()
+			return
+		}
+
+		policy, limiter := table.Match(c.Request, c.FullPath())
+		if policy == nil {
+			c.Next()
+			return
+		}
+
+		ctx := c.Request.Context()
+		id := policy.ID(c.Request)
+
+		decision, err := policy.Decide(ctx, limiter, id)
+		if err != nil {
+			panic(err)
+		}
+
+		speedbump.AddDecisionEvent(ctx, limiter, decision.Allowed)
+
+		if !decision.Allowed {
+			limited(c, limiter.Hasher())
+			return
+		}
+
+		if decision.Shadowed() && policy.ShadowHeader != "" {
+			c.Header(policy.ShadowHeader, policy.Name)
+		}
+
+		c.Request = policy.WithCountFlag(c.Request)
+		c.Next()
+
+		// The response was already sent, so a failed refund or count is
+		// ignored.
+		policy.Settle(c.Request, limiter, id, decision, c.Writer.Status())
 	}
 }
 
-// RateLimitLB is very similar to RateLimit but it takes the X-Forwarded-For
-// header in cosideration when trying to figure the IP address of the client.
-// This is useful for when running a server behind a load balancer or proxy.
+// peerKey is the default key of the middleware, which identifies clients by
+// the address of the connecting peer.
+func peerKey(r *http.Request) string {
+	return httpbump.IPKey(httpbump.PeerAddress(r))
+}
+
+// forwardedKey identifies clients by their forwarded address. See RateLimitLB.
+func forwardedKey(r *http.Request) string {
+	return httpbump.IPKey(forwardedAddress(r))
+}
+
+// limited aborts the request with the response sent to clients that exceeded
+// the limit.
+func limited(c *gin.Context, hasher speedbump.RateHasher) {
+	nextTime := time.Now().Add(hasher.Duration())
+
+	c.JSON(429, gin.H{
+		"status":   "error",
+		"messages": []string{"Rate limit exceeded. Try again in " + humanize.Time(nextTime)},
+	})
+	c.Abort()
+}
+
+// LimitConcurrency is a Gin middleware that limits the number of requests of
+// each client that are handled at the same time. The slot taken by a request
+// is released once the rest of the chain returns.
 //
-// However, this header can be spoofed by the client, so in some cases it could
-// provide a way of getting around the rate limiter.
+// The key determines the id of the client. If it is nil, the IP address of the
+// connecting peer is used. Requests matched by any of the skippers are not
+// limited.
 //
-// When using this middleware, make sure the load balancer will strip any
-// X-Forwarded-For headers set by the client, and that the server will not be
-// publicly accessible by the public, just the load balancer.
-func RateLimitLB(client *redis.Client, hasher speedbump.RateHasher, max int64) gin.HandlerFunc {
-	limiter := speedbump.NewLimiter(client, hasher, max)
+//  exports := speedbump.NewConcurrencyLimiter(client, 3, time.Minute)
+//
+//  router.POST("/exports", ginbump.LimitConcurrency(exports, byCustomer), export)
+func LimitConcurrency(
+	limiter *speedbump.ConcurrencyLimiter,
+	key func(r *http.Request) string,
+	skippers ...httpbump.Skipper,
+) gin.HandlerFunc {
+	skip := httpbump.Skip(skippers...)
+	policy := &httpbump.Policy{Key: key}
 
 	return func(c *gin.Context) {
-		// Attempt to perform the request
-		ip := GetRequesterAddress(c.Request)
-		ok, err := limiter.Attempt(ip)
+		if skip(c.Request) {
+			c.Next()
+			return
+		}
 
+		lease, ok, err := limiter.Acquire(policy.ID(c.Request))
 		if err != nil {
 			panic(err)
 		}
 
 		if !ok {
-			nextTime := time.Now().Add(hasher.Duration())
-
 			c.JSON(429, gin.H{
 				"status":   "error",
-				"messages": []string{"Rate limit exceeded. Try again in " + humanize.Time(nextTime)},
+				"messages": []string{"Too many concurrent requests. Try again once a request completes"},
 			})
 			c.Abort()
+			return
 		}
 
-		c.Next()
+		defer httpbump.HoldLease(limiter, lease)()
 
-		// After the request
-		// log.Print(ip + " was limited because it exceeded the max rate")
+		c.Next()
 	}
 }
@@ -2,12 +2,40 @@ package ginbump
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
 
 	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/httpbump"
+	"github.com/etcinit/speedbump/speedbumptest"
 	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"gopkg.in/redis.v5"
 )
 
+func createClient() *redis.Client {
+	if os.Getenv("WERCKER_REDIS_HOST") != "" {
+		return redis.NewClient(&redis.Options{
+			Addr:     os.Getenv("WERCKER_REDIS_HOST") + ":6379",
+			Password: "",
+			DB:       0,
+		})
+	}
+
+	return redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "",
+		DB:       0,
+	})
+}
+
+func teardown(t *testing.T, client *redis.Client) {
+	// Flush Redis.
+	require.NoError(t, client.FlushAll().Err())
+}
+
 // The following example shows how to set up a rate limitting middleware in Gin
 // that allows 100 requests per client per minute.
 func ExampleRateLimit() {
@@ -33,3 +61,132 @@ func ExampleRateLimit() {
 	// Start listening
 	router.Run(":8080")
 }
+
+func TestRateLimitSkip(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The client points to a server that does not exist, so any request that
+	// reaches Redis would fail.
+	client := redis.NewClient(&redis.Options{Addr: "localhost:1", MaxRetries: 0})
+
+	router := gin.New()
+	router.Use(RateLimit(
+		client,
+		speedbump.PerMinuteHasher{},
+		100,
+		httpbump.SkipPaths("/health"),
+		httpbump.SkipMethods("OPTIONS"),
+	))
+	router.Any("/health", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+	router.Any("/users", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	// Each skipper exempts requests on its own: the path skips every method,
+	// and the method skips every path.
+	for _, route := range []struct{ method, path string }{
+		{"GET", "/health"},
+		{"OPTIONS", "/users"},
+	} {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest(route.method, route.path, nil)
+		request.RemoteAddr = "8.8.8.8:30475"
+
+		router.ServeHTTP(recorder, request)
+		assert.Equal(t, http.StatusOK, recorder.Code)
+	}
+
+	// Other requests reach Redis, which fails.
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("GET", "/users", nil)
+	request.RemoteAddr = "8.8.8.8:30475"
+
+	assert.Panics(t, func() { router.ServeHTTP(recorder, request) })
+}
+
+func TestRateLimitLBWithoutPublicAddress(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	router := gin.New()
+	router.Use(RateLimitLB(client, speedbump.PerMinuteHasher{}, 1))
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	request := func(remoteAddr string) int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/", nil)
+		request.RemoteAddr = remoteAddr
+		// The header has no public address, so the peer address is used.
+		request.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+		router.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+
+	// Clients without a public forwarded address don't share a counter.
+	assert.Equal(t, http.StatusOK, request("8.8.8.8:30475"))
+	assert.Equal(t, http.StatusOK, request("9.9.9.9:30475"))
+	assert.Equal(t, http.StatusTooManyRequests, request("8.8.8.8:30475"))
+
+	// Neither do clients whose address can't be parsed.
+	assert.Equal(t, http.StatusOK, request("pipe"))
+	assert.Equal(t, http.StatusOK, request(""))
+	assert.Equal(t, http.StatusTooManyRequests, request(""))
+}
+
+func TestRateLimitWithFakeLimiter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// The fake counts attempts in memory, so no Redis server is needed.
+	limiter := speedbumptest.NewLimiter(speedbump.PerMinuteHasher{Clock: speedbumptest.NewClock()}, 1)
+
+	router := gin.New()
+	router.Use(RateLimitWithLimiter(limiter, nil))
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	request := func() int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/", nil)
+		request.RemoteAddr = "8.8.8.8:30475"
+
+		router.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request())
+	assert.Equal(t, http.StatusTooManyRequests, request())
+	assert.Equal(t, 2, limiter.Calls())
+	assert.Equal(t, int64(1), limiter.Attempted(httpbump.IPKey("8.8.8.8")))
+}
+
+func TestRateLimitWithLimiterUpdate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	limiter := speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 1, speedbump.WithUpdates())
+
+	router := gin.New()
+	router.Use(RateLimitWithLimiter(limiter, nil))
+	router.GET("/", func(c *gin.Context) { c.String(http.StatusOK, "ok") })
+
+	// The period is changed after the middleware was created.
+	require.NoError(t, limiter.Update(speedbump.PerHourHasher{}, 1))
+
+	var recorder *httptest.ResponseRecorder
+	for i := 0; i < 2; i++ {
+		recorder = httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/", nil)
+		request.RemoteAddr = "8.8.8.8:30475"
+
+		router.ServeHTTP(recorder, request)
+	}
+
+	assert.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	assert.Regexp(t, "Try again in (59 minutes|1 hour) from now", recorder.Body.String())
+}
@@ -4,13 +4,24 @@ import (
 	"net"
 	"net/http"
 	"strings"
+
+	"github.com/etcinit/speedbump/httpbump"
 )
 
 // Originally from: https://github.com/sebest/xff/blob/master/xff.go
 
 var privateMasks = func() []net.IPNet {
 	masks := []net.IPNet{}
-	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7"} {
+	for _, cidr := range []string{
+		// Private networks.
+		"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "fc00::/7",
+		// Carrier-grade NAT.
+		"100.64.0.0/10",
+		// Loopback and link-local.
+		"127.0.0.0/8", "::1/128", "169.254.0.0/16", "fe80::/10",
+		// Documentation.
+		"192.0.2.0/24", "198.51.100.0/24", "203.0.113.0/24", "2001:db8::/32",
+	} {
 		if _, network, err := net.ParseCIDR(cidr); err != nil {
 			panic(err)
 		} else {
@@ -61,7 +72,11 @@ func ParseForwarded(ipList string) string {
 // For uses such as rate limitting, only use this function if you can trust that
 // the load balancer will strip the header from the client and that the server
 // will not be directly accessible by the public (only though the load
-// balancer).
+// balancer). Otherwise, use a httpbump.Resolver configured with the addresses
+// of the trusted proxies.
+//
+// When no address is found, it returns an empty string. Use it as the key of a
+// limiter through RateLimitLB, which falls back to httpbump.PeerAddress.
 func GetRequesterAddress(r *http.Request) string {
 	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
 		return ParseForwarded(xff)
@@ -73,3 +88,14 @@ func GetRequesterAddress(r *http.Request) string {
 
 	return ""
 }
+
+// forwardedAddress is like GetRequesterAddress, but it falls back to the
+// address of the peer when no address is found, so that those clients don't
+// share a single counter.
+func forwardedAddress(r *http.Request) string {
+	if address := GetRequesterAddress(r); address != "" {
+		return address
+	}
+
+	return httpbump.PeerAddress(r)
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/etcinit/speedbump/httpbump"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -24,6 +25,17 @@ func TestIsPublicIP(t *testing.T) {
 
 	parsed = net.ParseIP("::0")
 	assert.False(t, IsPublicIP(parsed))
+
+	for _, ip := range []string{
+		"100.64.0.1", "127.0.0.1", "::1", "169.254.10.1", "fe80::1",
+		"192.0.2.1", "198.51.100.1", "203.0.113.1", "2001:db8::1",
+		"::ffff:10.0.0.1",
+	} {
+		assert.False(t, IsPublicIP(net.ParseIP(ip)), ip)
+	}
+
+	parsed = net.ParseIP("2606:4700::1111")
+	assert.True(t, IsPublicIP(parsed))
 }
 
 func TestParseForwarded(t *testing.T) {
@@ -62,3 +74,16 @@ func TestGetRequesterAddress(t *testing.T) {
 	address = GetRequesterAddress(request)
 	assert.Equal(t, "208.0.0.1", address)
 }
+
+func TestForwardedAddress(t *testing.T) {
+	request, _ := http.NewRequest("GET", "test", nil)
+
+	assert.Equal(t, httpbump.UnknownAddress, forwardedAddress(request))
+
+	request.RemoteAddr = "127.0.0.1:30475"
+	request.Header.Set("X-Forwarded-For", "10.0.0.3")
+	assert.Equal(t, "127.0.0.1", forwardedAddress(request))
+
+	request.Header.Set("X-Forwarded-For", "10.0.0.3, 8.8.8.8")
+	assert.Equal(t, "8.8.8.8", forwardedAddress(request))
+}
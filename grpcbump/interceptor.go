@@ -0,0 +1,208 @@
+// Package grpcbump provides Speedbump interceptors for gRPC servers.
+package grpcbump
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// UnaryServerInterceptor limits unary RPCs. Each RPC is counted under the id
+// returned by the key function. Any speedbump.Limiter can be used, such as the
+// fake in the speedbumptest package.
+//
+// Once a caller reaches the limit, RPCs fail with codes.ResourceExhausted. The
+// status includes a RetryInfo detail with the time left until the limit resets,
+// and a QuotaFailure detail naming the limit that was exceeded. See
+// LimitedError.
+//
+//	limiter := speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 100)
+//
+//	server := grpc.NewServer(
+//	  grpc.UnaryInterceptor(grpcbump.UnaryServerInterceptor(
+//	    limiter,
+//	    grpcbump.MetadataKey("x-api-key"),
+//	  )),
+//	)
+func UnaryServerInterceptor(limiter speedbump.Limiter, key KeyFunc) grpc.UnaryServerInterceptor {
+	return func(
+		ctx context.Context,
+		req interface{},
+		info *grpc.UnaryServerInfo,
+		handler grpc.UnaryHandler,
+	) (interface{}, error) {
+		if err := attempt(ctx, limiter, key(ctx, info.FullMethod)); err != nil {
+			return nil, err
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor limits streaming RPCs. Opening a stream counts as a
+// single attempt, regardless of the number of messages sent on it. See
+// StreamMessageInterceptor to limit messages.
+func StreamServerInterceptor(limiter speedbump.Limiter, key KeyFunc) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		if err := attempt(ss.Context(), limiter, key(ss.Context(), info.FullMethod)); err != nil {
+			return err
+		}
+
+		return handler(srv, ss)
+	}
+}
+
+// StreamMessageInterceptor limits the messages received on each stream of
+// streaming RPCs. Every message counts as an attempt under an id made of the
+// one returned by the key function and a random suffix unique to the stream,
+// so every stream has its own counter. Combine it with StreamServerInterceptor
+// to also limit how many streams a caller can open.
+//
+// When the limit is reached, receiving the next message fails with
+// codes.ResourceExhausted, which usually ends the stream.
+func StreamMessageInterceptor(limiter speedbump.Limiter, key KeyFunc) grpc.StreamServerInterceptor {
+	return func(
+		srv interface{},
+		ss grpc.ServerStream,
+		info *grpc.StreamServerInfo,
+		handler grpc.StreamHandler,
+	) error {
+		suffix, err := streamID()
+		if err != nil {
+			return status.Error(codes.Internal, "rate limiter unavailable")
+		}
+
+		return handler(srv, &limitedStream{
+			ServerStream: ss,
+			limiter:      limiter,
+			id:           key(ss.Context(), info.FullMethod) + ":stream:" + suffix,
+		})
+	}
+}
+
+// streamID generates a random id for a stream, which is unique across the
+// instances of a server that share a Redis server.
+func streamID() (string, error) {
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id[:]), nil
+}
+
+// limitedStream is a grpc.ServerStream that counts every received message.
+type limitedStream struct {
+	grpc.ServerStream
+	limiter speedbump.Limiter
+	id      string
+}
+
+// RecvMsg receives a message if the limit allows it.
+func (s *limitedStream) RecvMsg(m interface{}) error {
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+
+	return attempt(s.Context(), s.limiter, s.id)
+}
+
+// attempt performs an attempt for the id and converts the result into a gRPC
+// status error.
+//
+// Errors of the limiter are returned to callers as a generic codes.Internal
+// status, since they may reveal details of the Redis server. The limiter
+// already reports them to its metrics and spans, and they are also recorded on
+// the span of the RPC.
+func attempt(ctx context.Context, limiter speedbump.Limiter, id string) error {
+	decision, err := limiter.Decide(ctx, id)
+	if err != nil {
+		trace.SpanFromContext(ctx).RecordError(err)
+
+		return status.Error(codes.Internal, "rate limiter unavailable")
+	}
+
+	if decision.Allowed {
+		return nil
+	}
+
+	return LimitedError(limiter, id)
+}
+
+// LimitedError creates the status error returned to callers that exceeded the
+// limit of the limiter.
+//
+// The subject of the QuotaFailure detail names the limit instead of the id,
+// which may be a secret such as an API key: it is "policy:" followed by the
+// namespace of the limiter, or its name if it has no namespace. Limiters with
+// neither, and limiters other than speedbump.RateLimiter, use "key:" followed
+// by a SHA-256 hash of the id, which lets callers tell their limits apart
+// without revealing it.
+func LimitedError(limiter speedbump.Limiter, id string) error {
+	st := status.New(codes.ResourceExhausted, "rate limit exceeded")
+
+	detailed, err := st.WithDetails(
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(retryAfter(limiter)),
+		},
+		&errdetails.QuotaFailure{
+			Violations: []*errdetails.QuotaFailure_Violation{{
+				Subject:     quotaSubject(limiter, id),
+				Description: "rate limit exceeded",
+			}},
+		},
+	)
+	if err != nil {
+		return st.Err()
+	}
+
+	return detailed.Err()
+}
+
+// retryAfter returns how long callers have to wait for the limit to reset,
+// which is the time left in the current period if the hasher of the limiter
+// has periods, or a whole period otherwise.
+func retryAfter(limiter speedbump.Limiter) time.Duration {
+	if limiter, ok := limiter.(interface{ RetryAfter() time.Duration }); ok {
+		return limiter.RetryAfter()
+	}
+
+	hasher := limiter.Hasher()
+	if hasher, ok := hasher.(speedbump.PeriodHasher); ok {
+		return hasher.PeriodEnd().Sub(hasher.Now())
+	}
+
+	return hasher.Duration()
+}
+
+// quotaSubject generates the subject of the QuotaFailure detail of an id.
+func quotaSubject(limiter speedbump.Limiter, id string) string {
+	if limiter, ok := limiter.(*speedbump.RateLimiter); ok {
+		if namespace := limiter.Namespace(); namespace != "" {
+			return "policy:" + namespace
+		}
+
+		if name := limiter.Name(); name != "" {
+			return "policy:" + name
+		}
+	}
+
+	sum := sha256.Sum256([]byte(id))
+
+	return "key:" + hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,225 @@
+package grpcbump
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/speedbumptest"
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/redis.v5"
+)
+
+func createClient() *redis.Client {
+	if os.Getenv("WERCKER_REDIS_HOST") != "" {
+		return redis.NewClient(&redis.Options{
+			Addr:     os.Getenv("WERCKER_REDIS_HOST") + ":6379",
+			Password: "",
+			DB:       0,
+		})
+	}
+
+	return redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "",
+		DB:       0,
+	})
+}
+
+func teardown(t *testing.T, client *redis.Client) {
+	// Flush Redis.
+	require.NoError(t, client.FlushAll().Err())
+}
+
+func peerContext(addr string) context.Context {
+	return peer.NewContext(context.Background(), &peer.Peer{
+		Addr: &net.TCPAddr{IP: net.ParseIP(addr), Port: 30475},
+	})
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	mock := clock.NewMock()
+	mock.Add(20 * time.Second)
+	limiter := speedbump.NewLimiter(client, speedbump.PerMinuteHasher{Clock: mock}, 2)
+	interceptor := UnaryServerInterceptor(limiter, PeerKey)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+	ctx := peerContext("8.8.8.8")
+
+	for i := 0; i < 2; i++ {
+		resp, err := interceptor(ctx, nil, info, handler)
+		require.NoError(t, err)
+		assert.Equal(t, "ok", resp)
+	}
+
+	_, err := interceptor(ctx, nil, info, handler)
+	require.Error(t, err)
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 2)
+
+	retry, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Equal(t, 40*time.Second, retry.RetryDelay.AsDuration())
+
+	// The subject doesn't reveal the id.
+	quota, ok := st.Details()[1].(*errdetails.QuotaFailure)
+	require.True(t, ok)
+	require.Len(t, quota.Violations, 1)
+	assert.NotContains(t, quota.Violations[0].Subject, "8.8.8.8")
+	assert.Regexp(t, "^key:[0-9a-f]{64}$", quota.Violations[0].Subject)
+
+	// Other peers have their own counters.
+	_, err = interceptor(peerContext("8.8.4.4"), nil, info, handler)
+	assert.NoError(t, err)
+}
+
+// fakeStream is a grpc.ServerStream that receives a fixed number of messages.
+type fakeStream struct {
+	grpc.ServerStream
+	ctx      context.Context
+	messages int
+}
+
+func (s *fakeStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	if s.messages == 0 {
+		return io.EOF
+	}
+
+	s.messages--
+
+	return nil
+}
+
+func TestStreamInterceptors(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	info := &grpc.StreamServerInfo{FullMethod: "/test.Service/Stream"}
+	received := 0
+	handler := func(srv interface{}, ss grpc.ServerStream) error {
+		for {
+			if err := ss.RecvMsg(nil); err != nil {
+				if err == io.EOF {
+					return nil
+				}
+
+				return err
+			}
+
+			received++
+		}
+	}
+
+	// Opening streams is limited per method.
+	streams := StreamServerInterceptor(
+		speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 1, speedbump.WithNamespace("streams")),
+		MethodKey,
+	)
+	require.NoError(t, streams(nil, &fakeStream{ctx: peerContext("8.8.8.8")}, info, handler))
+	err := streams(nil, &fakeStream{ctx: peerContext("8.8.4.4")}, info, handler)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+
+	// Messages are limited per stream.
+	messages := StreamMessageInterceptor(
+		speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 3, speedbump.WithNamespace("messages")),
+		PeerKey,
+	)
+	for i := 0; i < 2; i++ {
+		received = 0
+		err = messages(nil, &fakeStream{ctx: peerContext("8.8.8.8"), messages: 5}, info, handler)
+		assert.Equal(t, codes.ResourceExhausted, status.Code(err))
+		assert.Equal(t, 3, received)
+	}
+}
+
+func TestLimitedError(t *testing.T) {
+	// Limiters with a namespace or a name report it as the subject.
+	limiters := map[string]*speedbump.RateLimiter{
+		"policy:login": speedbump.NewLimiter(nil, speedbump.PerMinuteHasher{}, 1, speedbump.WithNamespace("login")),
+		"policy:api":   speedbump.NewLimiter(nil, speedbump.PerMinuteHasher{}, 1, speedbump.WithName("api")),
+	}
+
+	for subject, limiter := range limiters {
+		st := status.Convert(LimitedError(limiter, "x-api-key=secret"))
+		require.Len(t, st.Details(), 2)
+
+		quota, ok := st.Details()[1].(*errdetails.QuotaFailure)
+		require.True(t, ok)
+		assert.Equal(t, subject, quota.Violations[0].Subject)
+	}
+}
+
+func TestUnaryServerInterceptorError(t *testing.T) {
+	// The client points to a server that does not exist.
+	client := redis.NewClient(&redis.Options{Addr: "localhost:1", MaxRetries: 0})
+	interceptor := UnaryServerInterceptor(speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 1), PeerKey)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	// The error of the store is not revealed to the caller.
+	_, err := interceptor(peerContext("8.8.8.8"), nil, info, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.Internal, st.Code())
+	assert.Equal(t, "rate limiter unavailable", st.Message())
+}
+
+func TestUnaryServerInterceptorWithFakeLimiter(t *testing.T) {
+	// The fake counts attempts in memory, so no Redis server is needed.
+	mock := speedbumptest.NewClock()
+	speedbumptest.Advance(mock, 20*time.Second)
+	limiter := speedbumptest.NewLimiter(speedbump.PerMinuteHasher{Clock: mock}, 1)
+	interceptor := UnaryServerInterceptor(limiter, PeerKey)
+
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "ok", nil
+	}
+
+	_, err := interceptor(peerContext("8.8.8.8"), nil, info, handler)
+	require.NoError(t, err)
+
+	_, err = interceptor(peerContext("8.8.8.8"), nil, info, handler)
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	require.Len(t, st.Details(), 2)
+
+	retry, ok := st.Details()[0].(*errdetails.RetryInfo)
+	require.True(t, ok)
+	assert.Equal(t, 40*time.Second, retry.RetryDelay.AsDuration())
+
+	// Errors of the limiter are hidden from callers.
+	limiter.Fail(errors.New("connection refused"))
+
+	_, err = interceptor(peerContext("8.8.4.4"), nil, info, handler)
+	assert.Equal(t, codes.Internal, status.Code(err))
+	assert.Equal(t, 3, limiter.Calls())
+}
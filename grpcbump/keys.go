@@ -0,0 +1,81 @@
+package grpcbump
+
+import (
+	"context"
+	"strings"
+
+	"github.com/etcinit/speedbump/httpbump"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// KeyFunc determines the id under which an RPC is counted. It receives the
+// context of the RPC and the full name of the method, such as
+// "/package.Service/Method".
+type KeyFunc func(ctx context.Context, fullMethod string) string
+
+// PeerKey counts RPCs by the IP address of the peer that made them. IPv6 peers
+// are grouped by prefix, as described in httpbump.IPKey.
+func PeerKey(ctx context.Context, fullMethod string) string {
+	return peerKey(ctx, httpbump.DefaultIPv6PrefixLength)
+}
+
+// PeerPrefixKey is like PeerKey, but it groups IPv6 peers by the provided
+// prefix length. A length of 128 counts every IPv6 address separately.
+func PeerPrefixKey(ipv6Prefix int) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		return peerKey(ctx, ipv6Prefix)
+	}
+}
+
+// peerKey returns the normalized IP address of the peer of an RPC.
+func peerKey(ctx context.Context, ipv6Prefix int) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return httpbump.UnknownAddress
+	}
+
+	addr := p.Addr.String()
+	if ip := httpbump.ParseRemoteAddr(addr); ip != nil {
+		return httpbump.NormalizeIP(ip.String(), ipv6Prefix)
+	}
+
+	return addr
+}
+
+// MethodKey counts RPCs by the full name of the method, which results in a
+// limit shared by all callers of each method.
+func MethodKey(ctx context.Context, fullMethod string) string {
+	return fullMethod
+}
+
+// MetadataKey counts RPCs by the value of an incoming metadata entry, such as
+// an API key. RPCs without the entry are counted by peer address instead.
+func MetadataKey(name string) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		md, _ := metadata.FromIncomingContext(ctx)
+
+		for _, value := range md.Get(name) {
+			if value != "" {
+				return name + "=" + value
+			}
+		}
+
+		return PeerKey(ctx, fullMethod)
+	}
+}
+
+// JoinKeys combines multiple key functions, so that RPCs are counted
+// separately for each combination of their values. For example, joining
+// MetadataKey("x-api-key") and MethodKey results in a limit per API key and
+// method.
+func JoinKeys(keys ...KeyFunc) KeyFunc {
+	return func(ctx context.Context, fullMethod string) string {
+		parts := make([]string, 0, len(keys))
+		for _, key := range keys {
+			parts = append(parts, key(ctx, fullMethod))
+		}
+
+		return strings.Join(parts, "|")
+	}
+}
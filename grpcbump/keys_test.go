@@ -0,0 +1,37 @@
+package grpcbump
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPeerKey(t *testing.T) {
+	assert.Equal(t, "8.8.8.8", PeerKey(peerContext("8.8.8.8"), ""))
+	assert.Equal(t, "2001:db8::/64", PeerKey(peerContext("2001:db8::1"), ""))
+	assert.Equal(t, "unknown", PeerKey(context.Background(), ""))
+}
+
+func TestPeerPrefixKey(t *testing.T) {
+	assert.Equal(t, "2001:db8::/48", PeerPrefixKey(48)(peerContext("2001:db8:0:1::1"), ""))
+	assert.Equal(t, "2001:db8::1", PeerPrefixKey(128)(peerContext("2001:db8::1"), ""))
+	assert.Equal(t, "8.8.8.8", PeerPrefixKey(48)(peerContext("8.8.8.8"), ""))
+}
+
+func TestMetadataKey(t *testing.T) {
+	key := MetadataKey("x-api-key")
+
+	ctx := metadata.NewIncomingContext(peerContext("8.8.8.8"), metadata.Pairs("x-api-key", "secret"))
+	assert.Equal(t, "x-api-key=secret", key(ctx, ""))
+
+	// Callers without the entry are counted by address.
+	assert.Equal(t, "8.8.8.8", key(peerContext("8.8.8.8"), ""))
+}
+
+func TestJoinKeys(t *testing.T) {
+	key := JoinKeys(PeerKey, MethodKey)
+
+	assert.Equal(t, "8.8.8.8|/test.Service/Method", key(peerContext("8.8.8.8"), "/test.Service/Method"))
+}
@@ -1,12 +1,33 @@
 package speedbump
 
 import (
+	"fmt"
 	"strconv"
 	"time"
 
 	"github.com/facebookgo/clock"
 )
 
+// PeriodHasher is a RateHasher whose periods start at fixed points in time,
+// such as the start of every minute. Knowing when the current period ends lets
+// the limiter tell clients exactly when they can try again.
+type PeriodHasher interface {
+	RateHasher
+	// Now returns the current time according to the hasher's clock.
+	Now() time.Time
+	// PeriodEnd returns the time at which the current period ends.
+	PeriodEnd() time.Time
+}
+
+// TimeHasher is a PeriodHasher that can generate the hash of any period, not
+// only the current one, which is needed to reserve attempts in later periods.
+// See RateLimiter.Reserve.
+type TimeHasher interface {
+	PeriodHasher
+	// HashAt generates the hash for the period that contains t and client.
+	HashAt(id string, t time.Time) string
+}
+
 // PerSecondHasher generates hashes per second. This means you can keep track
 // of N request per second.
 type PerSecondHasher struct {
@@ -18,11 +39,12 @@ type PerSecondHasher struct {
 
 // Hash generates the hash for the current period and client.
 func (h PerSecondHasher) Hash(id string) string {
-	if h.Clock == nil {
-		h.Clock = clock.New()
-	}
+	return h.HashAt(id, h.Now())
+}
 
-	return id + ":" + strconv.FormatInt(h.Clock.Now().Unix(), 10)
+// HashAt generates the hash for the period that contains t and client.
+func (h PerSecondHasher) HashAt(id string, t time.Time) string {
+	return id + ":" + strconv.FormatInt(t.Unix(), 10)
 }
 
 // Duration gets the duration of each period.
@@ -30,6 +52,20 @@ func (h PerSecondHasher) Duration() time.Duration {
 	return time.Second
 }
 
+// Now returns the current time according to the hasher's clock.
+func (h PerSecondHasher) Now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+
+	return h.Clock.Now()
+}
+
+// PeriodEnd returns the time at which the current period ends.
+func (h PerSecondHasher) PeriodEnd() time.Time {
+	return time.Unix(h.Now().Unix()+1, 0)
+}
+
 // PerMinuteHasher generates hashes per minute. This means you can keep track
 // of N request per minute.
 type PerMinuteHasher struct {
@@ -38,11 +74,12 @@ type PerMinuteHasher struct {
 
 // Hash generates the hash for the current period and client.
 func (h PerMinuteHasher) Hash(id string) string {
-	if h.Clock == nil {
-		h.Clock = clock.New()
-	}
+	return h.HashAt(id, h.Now())
+}
 
-	return id + ":" + h.Clock.Now().Format("2006-01-02T15:04")
+// HashAt generates the hash for the period that contains t and client.
+func (h PerMinuteHasher) HashAt(id string, t time.Time) string {
+	return id + ":" + t.Format("2006-01-02T15:04")
 }
 
 // Duration gets the duration of each period.
@@ -50,6 +87,23 @@ func (h PerMinuteHasher) Duration() time.Duration {
 	return time.Minute
 }
 
+// Now returns the current time according to the hasher's clock.
+func (h PerMinuteHasher) Now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+
+	return h.Clock.Now()
+}
+
+// PeriodEnd returns the time at which the current period ends.
+func (h PerMinuteHasher) PeriodEnd() time.Time {
+	now := h.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), now.Minute(), 0, 0, now.Location())
+
+	return start.Add(time.Minute)
+}
+
 // PerHourHasher generates hashes per hour. This means you can keep track
 // of N request per hour.
 type PerHourHasher struct {
@@ -58,14 +112,91 @@ type PerHourHasher struct {
 
 // Hash generates the hash for the current period and client.
 func (h PerHourHasher) Hash(id string) string {
-	if h.Clock == nil {
-		h.Clock = clock.New()
-	}
+	return h.HashAt(id, h.Now())
+}
 
-	return id + ":" + h.Clock.Now().Format("2006-01-02T15")
+// HashAt generates the hash for the period that contains t and client.
+func (h PerHourHasher) HashAt(id string, t time.Time) string {
+	return id + ":" + t.Format("2006-01-02T15")
 }
 
 // Duration gets the duration of each period.
 func (h PerHourHasher) Duration() time.Duration {
 	return time.Hour
 }
+
+// Now returns the current time according to the hasher's clock.
+func (h PerHourHasher) Now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+
+	return h.Clock.Now()
+}
+
+// PeriodEnd returns the time at which the current period ends.
+func (h PerHourHasher) PeriodEnd() time.Time {
+	now := h.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), 0, 0, 0, now.Location())
+
+	return start.Add(time.Hour)
+}
+
+// WindowHasher generates hashes for fixed windows of any duration, such as 10
+// seconds or 15 minutes. Windows are aligned to the Unix epoch, so every
+// limiter with the same window shares the same boundaries.
+type WindowHasher struct {
+	// Window is the duration of each period. It must be at least a
+	// millisecond, which is the precision of counter expirations.
+	Window time.Duration
+	Clock  clock.Clock
+}
+
+// Hash generates the hash for the current period and client.
+func (h WindowHasher) Hash(id string) string {
+	return h.HashAt(id, h.Now())
+}
+
+// HashAt generates the hash for the period that contains t and client.
+func (h WindowHasher) HashAt(id string, t time.Time) string {
+	return id + ":w" + strconv.FormatInt(t.UnixNano()/int64(h.Window), 10)
+}
+
+// Duration gets the duration of each period.
+func (h WindowHasher) Duration() time.Duration {
+	return h.Window
+}
+
+// Now returns the current time according to the hasher's clock.
+func (h WindowHasher) Now() time.Time {
+	if h.Clock == nil {
+		return time.Now()
+	}
+
+	return h.Clock.Now()
+}
+
+// PeriodEnd returns the time at which the current period ends.
+func (h WindowHasher) PeriodEnd() time.Time {
+	return time.Unix(0, (h.window()+1)*int64(h.Window))
+}
+
+// window returns the number of the current window since the Unix epoch.
+func (h WindowHasher) window() int64 {
+	return h.Now().UnixNano() / int64(h.Window)
+}
+
+// ParseHasher returns the built-in hasher for the provided period, which can
+// be "second", "minute" or "hour".
+func ParseHasher(period string) (RateHasher, error) {
+	switch period {
+	case "second":
+		return PerSecondHasher{}, nil
+	case "minute":
+		return PerMinuteHasher{}, nil
+	case "hour":
+		return PerHourHasher{}, nil
+	default:
+		return nil, fmt.Errorf("speedbump: unknown period %q", period)
+	}
+}
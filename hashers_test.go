@@ -132,3 +132,59 @@ func Test_PerHour_Duration(t *testing.T) {
 
 	assert.Equal(t, time.Hour, hasher.Duration())
 }
+
+func Test_PeriodEnd(t *testing.T) {
+	mock := clock.NewMock()
+	mock.Add(90*time.Minute + 30*time.Second + 500*time.Millisecond)
+
+	perSecond := PerSecondHasher{Clock: mock}
+	assert.Equal(t, mock.Now().Truncate(time.Second).Add(time.Second), perSecond.PeriodEnd())
+	assert.Equal(t, mock.Now(), perSecond.Now())
+
+	perMinute := PerMinuteHasher{Clock: mock}
+	assert.Equal(t, mock.Now().Truncate(time.Minute).Add(time.Minute), perMinute.PeriodEnd())
+
+	perHour := PerHourHasher{Clock: mock}
+	assert.Equal(t, mock.Now().Truncate(time.Hour).Add(time.Hour), perHour.PeriodEnd())
+
+	// The period ends when the hash changes.
+	hash := perMinute.Hash("127.0.0.1")
+	mock.Add(perMinute.PeriodEnd().Sub(mock.Now()) - time.Nanosecond)
+	assert.Equal(t, hash, perMinute.Hash("127.0.0.1"))
+	mock.Add(time.Nanosecond)
+	assert.NotEqual(t, hash, perMinute.Hash("127.0.0.1"))
+}
+
+func Test_WindowHasher(t *testing.T) {
+	mock := clock.NewMock()
+	mock.Add(25 * time.Second)
+
+	hasher := WindowHasher{Window: 10 * time.Second, Clock: mock}
+	assert.Equal(t, 10*time.Second, hasher.Duration())
+	assert.Equal(t, "127.0.0.1:w2", hasher.Hash("127.0.0.1"))
+	assert.True(t, time.Unix(30, 0).Equal(hasher.PeriodEnd()))
+
+	// The period ends when the hash changes.
+	mock.Add(5*time.Second - time.Nanosecond)
+	assert.Equal(t, "127.0.0.1:w2", hasher.Hash("127.0.0.1"))
+	mock.Add(time.Nanosecond)
+	assert.Equal(t, "127.0.0.1:w3", hasher.Hash("127.0.0.1"))
+	assert.True(t, time.Unix(40, 0).Equal(hasher.PeriodEnd()))
+}
+
+func Test_ParseHasher(t *testing.T) {
+	hasher, err := ParseHasher("second")
+	assert.NoError(t, err)
+	assert.Equal(t, PerSecondHasher{}, hasher)
+
+	hasher, err = ParseHasher("minute")
+	assert.NoError(t, err)
+	assert.Equal(t, PerMinuteHasher{}, hasher)
+
+	hasher, err = ParseHasher("hour")
+	assert.NoError(t, err)
+	assert.Equal(t, PerHourHasher{}, hasher)
+
+	_, err = ParseHasher("fortnight")
+	assert.Error(t, err)
+}
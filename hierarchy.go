@@ -0,0 +1,306 @@
+package speedbump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// attemptHierarchyScript attempts a request on every level of a hierarchy, and
+// only counts it if every level allows it. For every level, KEYS has its
+// counter, ban and override keys, and ARGV has its max, the expiration of its
+// counter in milliseconds and whether it is in shadow mode.
+//
+// It returns the position of the first level that rejected the attempt, or 0
+// if it was allowed, followed by the room left, counter and limit of every
+// level and whether it is banned.
+var attemptHierarchyScript = redis.NewScript(`
+local levels = {}
+local rejected = 0
+
+for i = 1, #KEYS / 3 do
+  local counter, ban, override = KEYS[3 * i - 2], KEYS[3 * i - 1], KEYS[3 * i]
+  local limit = tonumber(redis.call("GET", override) or ARGV[3 * i - 2])
+  local attempted = tonumber(redis.call("GET", counter) or 0)
+  local banned = redis.call("EXISTS", ban)
+  local room = 0
+
+  if banned == 0 and attempted < limit then
+    room = 1
+  end
+
+  -- Levels in shadow mode never reject attempts.
+  if room == 0 and rejected == 0 and ARGV[3 * i] == "0" then
+    rejected = i
+  end
+
+  levels[i] = {room, attempted, limit, banned}
+end
+
+if rejected == 0 then
+  for i = 1, #KEYS / 3 do
+    if levels[i][1] == 1 then
+      levels[i][2] = redis.call("INCR", KEYS[3 * i - 2])
+      redis.call("PEXPIRE", KEYS[3 * i - 2], ARGV[3 * i - 1])
+    end
+  end
+end
+
+return {rejected, levels}
+`)
+
+// Level is a level of a Hierarchy, such as the user, the tenant or the whole
+// service.
+type Level struct {
+	// Name identifies the level in decisions, such as "tenant".
+	Name string
+	// Limiter keeps the counters of the level, and determines its hasher, max,
+	// namespace and behavior. Limiters of different levels should have
+	// different namespaces, so that their counters don't collide.
+	Limiter *RateLimiter
+}
+
+// Hierarchy limits requests at multiple levels in a single decision, such as
+// "each user 100/min, each tenant 1,000/min, the whole service 20,000/min". An
+// attempt is only allowed, and only counted, if every level allows it.
+//
+//	hierarchy, err := speedbump.NewHierarchy(
+//	  speedbump.Level{Name: "user", Limiter: users},
+//	  speedbump.Level{Name: "tenant", Limiter: tenants},
+//	  speedbump.Level{Name: "global", Limiter: global},
+//	)
+//
+//	decision, err := hierarchy.Attempt(ctx, userID, tenantID, "")
+//	if !decision.Allowed {
+//	  log.Printf("rejected by the %s limit", decision.RejectedBy)
+//	}
+//
+// Every level is evaluated by a single script, using the Redis client of the
+// first level. On a Redis Cluster, all the keys have to be in the same hash
+// slot, for example by using namespaces with a hash tag such as "{api}:user".
+//
+// Each level keeps the shadow mode, failure mode, escalation, metrics and
+// listeners of its limiter, but the denied cache is not used.
+type Hierarchy struct {
+	levels []Level
+}
+
+// HierarchyDecision is the outcome of an attempt on a Hierarchy.
+type HierarchyDecision struct {
+	// Allowed is whether the request should be served.
+	Allowed bool
+	// RejectedBy is the name of the first level that rejected the attempt, if
+	// it was rejected.
+	RejectedBy string
+	// Levels has the decision of every level, in the same order as the levels
+	// of the hierarchy. Counters are only incremented if the attempt was
+	// allowed, and levels in shadow mode are not incremented once they are
+	// over their limit, like in RateLimiter.Attempt.
+	//
+	// When the attempt is rejected, no level allows it, and only the level
+	// that rejected it reports the decision to its metrics and listeners. The
+	// other levels are marked as Limited only if they were over their limit.
+	Levels []Decision
+	// Remaining is the lowest number of attempts left in any level.
+	Remaining int64
+}
+
+// NewHierarchy creates a hierarchy with the provided levels, from the most
+// specific to the most general. Levels are checked in that order, so the
+// narrowest level that is over its limit is the one reported as rejecting.
+func NewHierarchy(levels ...Level) (*Hierarchy, error) {
+	if len(levels) == 0 {
+		return nil, errors.New("speedbump: a hierarchy needs at least one level")
+	}
+
+	names := map[string]bool{}
+	for i, level := range levels {
+		if level.Name == "" || level.Limiter == nil {
+			return nil, fmt.Errorf("speedbump: level %d needs a name and a limiter", i)
+		}
+
+		if level.Limiter.serverTime {
+			return nil, ErrServerTime
+		}
+
+		if names[level.Name] {
+			return nil, fmt.Errorf("speedbump: duplicate level %q", level.Name)
+		}
+
+		names[level.Name] = true
+	}
+
+	return &Hierarchy{levels: levels}, nil
+}
+
+// Levels returns the levels of the hierarchy.
+func (h *Hierarchy) Levels() []Level {
+	return h.levels
+}
+
+// Attempt attempts a request on every level of the hierarchy. It takes the id
+// of the request on every level, in the same order as the levels. Levels that
+// apply to every request, like a global one, can use a constant id.
+//
+// If the call fails, every level decides with its failure mode, and the
+// request is only allowed if every level allows it. The first error returned
+// by a level is returned.
+func (h *Hierarchy) Attempt(ctx context.Context, ids ...string) (HierarchyDecision, error) {
+	if len(ids) != len(h.levels) {
+		return HierarchyDecision{}, fmt.Errorf(
+			"speedbump: got %d ids for %d levels", len(ids), len(h.levels),
+		)
+	}
+
+	first := h.levels[0].Limiter
+	_, span := first.startSpanWith(ctx, "AttemptHierarchy", AttributeCount.Int(len(ids)))
+
+	start := time.Now()
+	rejected, results, err := h.attempt(ids)
+
+	if err != nil {
+		recordError(span, err)
+	}
+
+	decision := HierarchyDecision{Allowed: true, Levels: make([]Decision, len(ids))}
+	events := make([]Event, len(ids))
+
+	// Only the level that rejected the attempt reports a decision to its
+	// metrics and listeners, since the others were not counted.
+	reported := make([]bool, len(ids))
+
+	var firstErr error
+	for i, level := range h.levels {
+		if err != nil {
+			level.Limiter.observeError(err)
+		}
+
+		var levelDecision Decision
+		if err == nil && rejected >= 0 && i != rejected {
+			levelDecision = Decision{
+				Limited:   !results[i].ok,
+				Attempted: results[i].attempted,
+				Max:       results[i].max,
+				Remaining: left(results[i].attempted, results[i].max),
+			}
+		} else {
+			var event Event
+			var levelErr error
+
+			levelDecision, event, levelErr = level.Limiter.finish(ids[i], start, results[i], err)
+			level.Limiter.recordDecisions(levelDecision)
+			if levelErr != nil && firstErr == nil {
+				firstErr = levelErr
+			}
+
+			events[i] = event
+			reported[i] = true
+		}
+
+		decision.Levels[i] = levelDecision
+		decision.Allowed = decision.Allowed && levelDecision.Allowed
+
+		if i == 0 || levelDecision.Remaining < decision.Remaining {
+			decision.Remaining = levelDecision.Remaining
+		}
+	}
+
+	if rejected >= 0 {
+		decision.RejectedBy = h.levels[rejected].Name
+	}
+
+	if err == nil {
+		recordDecision(span, decision.Allowed, decision.Remaining)
+	}
+
+	span.End()
+
+	for i, level := range h.levels {
+		if reported[i] {
+			level.Limiter.notify(events[i], results[i].ok)
+		}
+	}
+
+	return decision, firstErr
+}
+
+// attempt performs an attempt on every level without handling errors. It
+// returns the index of the level that rejected the attempt, or -1.
+func (h *Hierarchy) attempt(ids []string) (int, []attemptResult, error) {
+	first := h.levels[0].Limiter
+	results := make([]attemptResult, len(ids))
+
+	keys := make([]string, 0, 3*len(ids))
+	args := make([]interface{}, 0, 3*len(ids))
+
+	for i, level := range h.levels {
+		limiter := level.Limiter
+		current := limiter.current()
+
+		keys = append(keys,
+			limiter.hashWith(current.hasher, ids[i]),
+			limiter.banKey(ids[i]),
+			limiter.overrideKey(ids[i]),
+		)
+		args = append(args, current.max, milliseconds(current.hasher.Duration()), shadowFlag(limiter))
+	}
+
+	start := time.Now()
+	reply, err := attemptHierarchyScript.Run(first.redisClient, keys, args...).Result()
+	first.observeLatency("script", start)
+
+	if err != nil {
+		return -1, results, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return -1, results, fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+	}
+
+	position, ok := values[0].(int64)
+	levels, valid := values[1].([]interface{})
+	if !ok || !valid || len(levels) != len(ids) {
+		return -1, results, fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+	}
+
+	for i := range levels {
+		result, ok := parseBatchResult(levels[i])
+		if !ok {
+			return -1, results, fmt.Errorf("speedbump: unexpected reply from script: %v", levels[i])
+		}
+
+		results[i] = result
+	}
+
+	rejected := int(position) - 1
+
+	// Levels are only counted if the attempt was allowed.
+	for i := range results {
+		if rejected < 0 && results[i].ok {
+			results[i].key, results[i].cost = keys[3*i], 1
+		}
+	}
+
+	// Only the level that rejected the attempt committed an offence, since the
+	// others would have allowed it.
+	if rejected >= 0 && !results[rejected].banned {
+		if err := h.levels[rejected].Limiter.escalate(ids[rejected]); err != nil {
+			return rejected, results, err
+		}
+	}
+
+	return rejected, results, nil
+}
+
+// shadowFlag converts the shadow mode of a limiter to an argument of a script.
+func shadowFlag(r *RateLimiter) int {
+	if r.shadow {
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,147 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func createHierarchy(t *testing.T, users, tenants, global *RateLimiter) *Hierarchy {
+	hierarchy, err := NewHierarchy(
+		Level{Name: "user", Limiter: users},
+		Level{Name: "tenant", Limiter: tenants},
+		Level{Name: "global", Limiter: global},
+	)
+	require.NoError(t, err)
+
+	return hierarchy
+}
+
+func TestHierarchy(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	userListener, tenantListener := &recordingListener{}, &recordingListener{}
+	userMetrics, tenantMetrics := &recordingMetrics{}, &recordingMetrics{}
+
+	users := NewLimiter(
+		client, PerMinuteHasher{}, 2,
+		WithNamespace("user"), WithListener(userListener), WithMetrics(userMetrics),
+	)
+	tenants := NewLimiter(
+		client, PerMinuteHasher{}, 3,
+		WithNamespace("tenant"), WithListener(tenantListener), WithMetrics(tenantMetrics),
+	)
+	global := NewLimiter(client, PerMinuteHasher{}, 5, WithNamespace("global"))
+	hierarchy := createHierarchy(t, users, tenants, global)
+
+	ctx := context.Background()
+	attempt := func(user, tenant string) HierarchyDecision {
+		decision, err := hierarchy.Attempt(ctx, user, tenant, "")
+		require.NoError(t, err)
+
+		return decision
+	}
+
+	decision := attempt("alice", "acme")
+	assert.True(t, decision.Allowed)
+	assert.Empty(t, decision.RejectedBy)
+	assert.Equal(t, int64(1), decision.Remaining)
+	require.Len(t, decision.Levels, 3)
+	assert.Equal(t, int64(1), decision.Levels[0].Attempted)
+	assert.Equal(t, int64(4), decision.Levels[2].Remaining)
+
+	assert.True(t, attempt("alice", "acme").Allowed)
+
+	// The user level rejects first, and nothing is counted.
+	decision = attempt("alice", "acme")
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "user", decision.RejectedBy)
+	assert.True(t, decision.Levels[0].Limited)
+	assert.False(t, decision.Levels[1].Allowed)
+	assert.False(t, decision.Levels[1].Limited)
+	assert.False(t, decision.Levels[1].Refundable())
+	assert.Equal(t, int64(1), decision.Levels[1].Remaining)
+
+	// Only the level that rejected the attempt reports it.
+	assert.Equal(t, []string{"allowed", "allowed", "limited"}, userListener.kinds)
+	assert.Equal(t, []bool{true, true, false}, userMetrics.decisions)
+	assert.Equal(t, []string{"allowed", "allowed"}, tenantListener.kinds)
+	assert.Equal(t, []bool{true, true}, tenantMetrics.decisions)
+
+	attempted, err := tenants.Attempted("acme")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), attempted)
+
+	// Other users of the tenant share its limit.
+	assert.True(t, attempt("bob", "acme").Allowed)
+
+	decision = attempt("bob", "acme")
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "tenant", decision.RejectedBy)
+
+	attempted, err = users.Attempted("bob")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), attempted)
+
+	// Every tenant shares the global limit.
+	assert.True(t, attempt("carol", "initech").Allowed)
+	assert.True(t, attempt("dave", "initech").Allowed)
+
+	decision = attempt("erin", "initech")
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, "global", decision.RejectedBy)
+}
+
+func TestHierarchyShadow(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	users := NewLimiter(client, PerMinuteHasher{}, 1, WithNamespace("user"))
+	tenants := NewLimiter(client, PerMinuteHasher{}, 1, WithNamespace("tenant"), WithShadowMode())
+	global := NewLimiter(client, PerMinuteHasher{}, 10, WithNamespace("global"))
+	hierarchy := createHierarchy(t, users, tenants, global)
+
+	ctx := context.Background()
+
+	_, err := hierarchy.Attempt(ctx, "alice", "acme", "")
+	require.NoError(t, err)
+
+	// The tenant level is over its limit, but only in shadow mode.
+	decision, err := hierarchy.Attempt(ctx, "bob", "acme", "")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.True(t, decision.Levels[1].Shadowed())
+
+	attempted, err := global.Attempted("")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), attempted)
+}
+
+func TestHierarchyErrors(t *testing.T) {
+	_, err := NewHierarchy()
+	assert.Error(t, err)
+
+	limiter := NewLimiter(createBrokenClient(), PerMinuteHasher{}, 1, WithFailureMode(FailOpen))
+
+	_, err = NewHierarchy(Level{Name: "user", Limiter: limiter}, Level{Name: "user", Limiter: limiter})
+	assert.Error(t, err)
+
+	_, err = NewHierarchy(Level{Name: "user"})
+	assert.Error(t, err)
+
+	hierarchy, err := NewHierarchy(Level{Name: "user", Limiter: limiter})
+	require.NoError(t, err)
+
+	_, err = hierarchy.Attempt(context.Background(), "a", "b")
+	assert.Error(t, err)
+
+	// Failures are decided by the failure mode of every level.
+	decision, err := hierarchy.Attempt(context.Background(), "a")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+}
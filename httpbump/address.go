@@ -0,0 +1,257 @@
+// Package httpbump provides helpers for using Speedbump with net/http servers.
+package httpbump
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// UnknownAddress is the address returned by a Resolver when it is not possible
+// to determine any address for a request. It is used instead of an empty string
+// so that such requests are still counted under a well-known key.
+const UnknownAddress = "unknown"
+
+// Resolver determines the address of the client that made a request when the
+// server sits behind one or more proxies or load balancers.
+//
+// Unlike a plain lookup of the X-Forwarded-For header, a Resolver only believes
+// entries that were appended by a trusted proxy. The header is walked from right
+// to left, starting at the address of the peer that connected to the server,
+// and the walk stops at the first address that does not belong to a trusted
+// proxy. Entries to the left of that address could have been set by the client
+// and are ignored. Headers that hold a single address, such as X-Real-IP, are
+// treated as a list with one hop.
+type Resolver struct {
+	// trusted is the list of networks that contain trusted proxies.
+	trusted []*net.IPNet
+	// header is the canonical name of the header that holds the forwarding
+	// information.
+	header string
+	// ipv6Prefix is the prefix length Key aggregates IPv6 addresses by, or 0
+	// for DefaultIPv6PrefixLength.
+	ipv6Prefix int
+}
+
+// peerResolver trusts no proxies, so it always resolves the address of the
+// connecting peer.
+var peerResolver = &Resolver{}
+
+// NewResolver creates a new Resolver that trusts proxies in the provided
+// networks. Each network can be written in CIDR notation (e.g. "10.0.0.0/8") or
+// as a single IP address. If no networks are provided, no proxies are trusted
+// and the resolver always returns the address of the connecting peer.
+//
+// The resolver reads the X-Forwarded-For header. Use NewHeaderResolver or a
+// Provider to read a different header.
+func NewResolver(trustedProxies ...string) (*Resolver, error) {
+	return NewHeaderResolver(HeaderXForwardedFor, trustedProxies...)
+}
+
+// NewHeaderResolver creates a new Resolver that reads the client address from
+// the provided header when the request comes from a trusted proxy.
+//
+// Besides X-Forwarded-For, the standard Forwarded header (RFC 7239) is
+// understood, and any other header is expected to contain a single IP address,
+// like X-Real-IP or CF-Connecting-IP do.
+func NewHeaderResolver(header string, trustedProxies ...string) (*Resolver, error) {
+	networks, err := ParseNetworks(trustedProxies...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Resolver{
+		trusted: networks,
+		header:  http.CanonicalHeaderKey(header),
+	}, nil
+}
+
+// Header returns the name of the header read by the resolver.
+func (r *Resolver) Header() string {
+	return r.header
+}
+
+// ParseNetworks parses a list of networks written in CIDR notation or as single
+// IP addresses.
+func ParseNetworks(cidrs ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+
+		// Accept single addresses by turning them into a network of one.
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, &net.ParseError{Type: "IP address", Text: cidr}
+			}
+
+			if ip4 := ip.To4(); ip4 != nil {
+				networks = append(networks, &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)})
+			} else {
+				networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)})
+			}
+
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+
+		networks = append(networks, network)
+	}
+
+	return networks, nil
+}
+
+// WithIPv6Prefix returns a copy of the resolver whose Key aggregates IPv6
+// addresses by the provided prefix length instead of DefaultIPv6PrefixLength.
+// A length of 128, or less than 1, counts every IPv6 address separately.
+func (r *Resolver) WithIPv6Prefix(length int) *Resolver {
+	if length < 1 {
+		length = 128
+	}
+
+	copied := *r
+	copied.ipv6Prefix = length
+
+	return &copied
+}
+
+// Key returns the address of the client that made the request, normalized so
+// that it can be used as the id of a rate limiter. See NormalizeIP.
+func (r *Resolver) Key(req *http.Request) string {
+	prefix := r.ipv6Prefix
+	if prefix == 0 {
+		prefix = DefaultIPv6PrefixLength
+	}
+
+	return NormalizeIP(r.Resolve(req), prefix)
+}
+
+// Trusts returns whether the given IP belongs to a trusted proxy.
+func (r *Resolver) Trusts(ip net.IP) bool {
+	for _, network := range r.trusted {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Resolve returns the address of the client that made the request. The
+// returned value is never empty: when the peer address cannot be parsed, the
+// raw RemoteAddr is used, and when that is missing too, UnknownAddress is
+// returned.
+func (r *Resolver) Resolve(req *http.Request) string {
+	peer := ParseRemoteAddr(req.RemoteAddr)
+	if peer == nil {
+		return PeerAddress(req)
+	}
+
+	// If the request didn't come from a trusted proxy, forwarding headers could
+	// have been set by anyone.
+	if !r.Trusts(peer) {
+		return peer.String()
+	}
+
+	client := peer
+	hops := r.hops(req.Header[r.header])
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := parseHop(hops[i])
+
+		// A malformed entry means we can't tell who added the entries to its
+		// left, so we stop at the last address we could verify.
+		if ip == nil {
+			break
+		}
+
+		client = ip
+
+		if !r.Trusts(ip) {
+			break
+		}
+	}
+
+	return client.String()
+}
+
+// PeerAddress returns the address of the peer that sent the request, ignoring
+// forwarding headers. Like Resolve, it never returns an empty value, so that
+// clients whose address is unknown don't share a counter with every other
+// such client by accident.
+func PeerAddress(req *http.Request) string {
+	if peer := ParseRemoteAddr(req.RemoteAddr); peer != nil {
+		return peer.String()
+	}
+
+	if req.RemoteAddr != "" {
+		return req.RemoteAddr
+	}
+
+	return UnknownAddress
+}
+
+// ParseRemoteAddr parses the value of http.Request.RemoteAddr, which is
+// usually in "host:port" form, and returns the IP address in it. It returns nil
+// if the address cannot be parsed.
+func ParseRemoteAddr(addr string) net.IP {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return net.ParseIP(host)
+	}
+
+	return net.ParseIP(addr)
+}
+
+// hops splits the values of the resolver's header into a single list of hops,
+// in the order in which they were added.
+func (r *Resolver) hops(values []string) []string {
+	switch r.header {
+	case HeaderXForwardedFor:
+		hops := []string{}
+
+		for _, value := range values {
+			for _, hop := range strings.Split(value, ",") {
+				hops = append(hops, strings.TrimSpace(hop))
+			}
+		}
+
+		return hops
+	case HeaderForwarded:
+		hops := []string{}
+
+		for _, value := range values {
+			hops = append(hops, ParseForwarded(value)...)
+		}
+
+		return hops
+	default:
+		// Headers with a single address are overwritten by the proxy that sets
+		// them, so only the last value is meaningful.
+		if len(values) == 0 {
+			return nil
+		}
+
+		return []string{strings.TrimSpace(values[len(values)-1])}
+	}
+}
+
+// parseHop parses a single entry of a forwarding header. Some proxies include
+// the port of the client, so "1.2.3.4:5678" and "[::1]:5678" are accepted too.
+// Obfuscated identifiers, such as "unknown" or "_hidden", are not addresses and
+// result in nil.
+func parseHop(hop string) net.IP {
+	if ip := net.ParseIP(hop); ip != nil {
+		return ip
+	}
+
+	if strings.HasPrefix(hop, "[") && strings.HasSuffix(hop, "]") {
+		return net.ParseIP(hop[1 : len(hop)-1])
+	}
+
+	return ParseRemoteAddr(hop)
+}
@@ -0,0 +1,134 @@
+package httpbump
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewResolver(t *testing.T) {
+	resolver, err := NewResolver("10.0.0.0/8", "192.168.1.1", "fd00::/8")
+	require.NoError(t, err)
+	assert.Len(t, resolver.trusted, 3)
+
+	_, err = NewResolver("10.0.0.0/33")
+	assert.Error(t, err)
+
+	_, err = NewResolver("not an ip")
+	assert.Error(t, err)
+}
+
+func TestResolverResolve(t *testing.T) {
+	resolver, err := NewResolver("10.0.0.0/8", "192.168.1.1")
+	require.NoError(t, err)
+
+	request, _ := http.NewRequest("GET", "test", nil)
+
+	// Without a peer address, the resolver never returns an empty key.
+	assert.Equal(t, UnknownAddress, resolver.Resolve(request))
+
+	request.RemoteAddr = "@"
+	assert.Equal(t, "@", resolver.Resolve(request))
+
+	// Headers are ignored when the peer is not a trusted proxy.
+	request.RemoteAddr = "8.8.8.8:30475"
+	request.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "8.8.8.8", resolver.Resolve(request))
+
+	// The first untrusted hop from the right is the client, even if the client
+	// tried to spoof other entries on the left.
+	request.RemoteAddr = "10.0.0.1:30475"
+	request.Header.Set("X-Forwarded-For", "1.2.3.4, 8.8.8.8, 192.168.1.1")
+	assert.Equal(t, "8.8.8.8", resolver.Resolve(request))
+
+	// Hops may be spread across multiple headers and include ports.
+	request.Header.Set("X-Forwarded-For", "1.2.3.4")
+	request.Header.Add("X-Forwarded-For", "[2001:db8::1]:4000, 10.1.1.1")
+	assert.Equal(t, "2001:db8::1", resolver.Resolve(request))
+
+	// When every hop is trusted, the leftmost one is used.
+	request.Header.Set("X-Forwarded-For", "10.0.0.5, 10.0.0.4")
+	assert.Equal(t, "10.0.0.5", resolver.Resolve(request))
+
+	// Malformed entries stop the walk at the last verified hop.
+	request.Header.Set("X-Forwarded-For", "8.8.8.8, garbage, 10.0.0.4")
+	assert.Equal(t, "10.0.0.4", resolver.Resolve(request))
+
+	// Without a header, the trusted peer itself is used.
+	request.Header.Del("X-Forwarded-For")
+	assert.Equal(t, "10.0.0.1", resolver.Resolve(request))
+}
+
+func TestResolverKey(t *testing.T) {
+	resolver, err := NewResolver()
+	require.NoError(t, err)
+
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.RemoteAddr = "[2001:db8:0:ffff::1]:30475"
+
+	// Every resolver has its own prefix length.
+	assert.Equal(t, "2001:db8:0:ffff::/64", resolver.Key(request))
+	assert.Equal(t, "2001:db8::/48", resolver.WithIPv6Prefix(48).Key(request))
+	assert.Equal(t, "2001:db8:0:ffff::1", resolver.WithIPv6Prefix(128).Key(request))
+	assert.Equal(t, "2001:db8:0:ffff::1", resolver.WithIPv6Prefix(0).Key(request))
+	assert.Equal(t, "2001:db8:0:ffff::/64", resolver.Key(request))
+
+	request.RemoteAddr = "8.8.8.8:30475"
+	assert.Equal(t, "8.8.8.8", resolver.WithIPv6Prefix(48).Key(request))
+}
+
+func TestPeerAddress(t *testing.T) {
+	request, _ := http.NewRequest("GET", "/", nil)
+	request.Header.Set("X-Forwarded-For", "8.8.8.8")
+
+	request.RemoteAddr = "[2001:db8::1]:30475"
+	assert.Equal(t, "2001:db8::1", PeerAddress(request))
+
+	request.RemoteAddr = "pipe"
+	assert.Equal(t, "pipe", PeerAddress(request))
+
+	request.RemoteAddr = ""
+	assert.Equal(t, UnknownAddress, PeerAddress(request))
+}
+
+func TestParseRemoteAddr(t *testing.T) {
+	assert.Equal(t, "127.0.0.1", ParseRemoteAddr("127.0.0.1:30475").String())
+	assert.Equal(t, "::1", ParseRemoteAddr("[::1]:30475").String())
+	assert.Equal(t, "127.0.0.1", ParseRemoteAddr("127.0.0.1").String())
+	assert.Nil(t, ParseRemoteAddr("localhost:30475"))
+	assert.Nil(t, ParseRemoteAddr(""))
+}
+
+func TestHeaderResolver(t *testing.T) {
+	request, _ := http.NewRequest("GET", "test", nil)
+	request.RemoteAddr = "10.0.0.1:30475"
+
+	resolver, err := NewHeaderResolver("forwarded", "10.0.0.0/8")
+	require.NoError(t, err)
+	assert.Equal(t, HeaderForwarded, resolver.Header())
+
+	request.Header.Set("Forwarded", `for=1.2.3.4, for="[2001:db8:cafe::17]:4711";proto=https, for=10.0.0.2`)
+	assert.Equal(t, "2001:db8:cafe::17", resolver.Resolve(request))
+
+	// Obfuscated identifiers stop the walk.
+	request.Header.Set("Forwarded", `for=1.2.3.4, for=_hidden, for=10.0.0.2`)
+	assert.Equal(t, "10.0.0.2", resolver.Resolve(request))
+
+	resolver, err = Cloudflare.Resolver("10.0.0.0/8")
+	require.NoError(t, err)
+
+	request.Header.Set("CF-Connecting-IP", "8.8.8.8")
+	request.Header.Set("X-Forwarded-For", "1.2.3.4")
+	assert.Equal(t, "8.8.8.8", resolver.Resolve(request))
+
+	// Invalid values fall back to the peer.
+	request.Header.Set("CF-Connecting-IP", "garbage")
+	assert.Equal(t, "10.0.0.1", resolver.Resolve(request))
+
+	// Untrusted peers can't set the header.
+	request.RemoteAddr = "1.1.1.1:30475"
+	request.Header.Set("CF-Connecting-IP", "8.8.8.8")
+	assert.Equal(t, "1.1.1.1", resolver.Resolve(request))
+}
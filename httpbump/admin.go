@@ -0,0 +1,347 @@
+package httpbump
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/etcinit/speedbump"
+)
+
+// Authenticator decides whether a request to the admin API is made by an
+// authorized operator.
+type Authenticator func(r *http.Request) bool
+
+// BearerToken authenticates requests that send any of the provided tokens in
+// an "Authorization: Bearer <token>" header.
+func BearerToken(tokens ...string) Authenticator {
+	return func(r *http.Request) bool {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			return false
+		}
+
+		token := strings.TrimPrefix(header, "Bearer ")
+		for _, expected := range tokens {
+			if expected != "" && secureCompare(token, expected) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// BasicAuth authenticates requests that send the provided username and
+// password using HTTP basic authentication.
+func BasicAuth(username, password string) Authenticator {
+	return func(r *http.Request) bool {
+		user, pass, ok := r.BasicAuth()
+
+		// Both are compared so that the time taken does not reveal which one
+		// was wrong.
+		userOK := secureCompare(user, username)
+		passOK := secureCompare(pass, password)
+
+		return ok && userOK && passOK && password != ""
+	}
+}
+
+// secureCompare compares two strings in constant time. The strings are hashed
+// first, so that the time taken does not reveal their length either.
+func secureCompare(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
+
+// NewAdminHandler creates a handler that exposes a JSON API to inspect and
+// manage the limits of a table of policies. It is meant to be mounted on an
+// internal admin port, and every request has to pass the authenticator. If the
+// authenticator is nil, every request is rejected.
+//
+// The API has the following endpoints:
+//
+//	GET    /policies                            list the policies
+//	GET    /ids/{id}                            state of an id in every policy
+//	DELETE /policies/{policy}/ids/{id}/counter  reset the counter of an id
+//	PUT    /policies/{policy}/ids/{id}/ban      ban an id, with {"duration": "1h"}
+//	DELETE /policies/{policy}/ids/{id}/ban      lift the ban of an id
+//	PUT    /policies/{policy}/ids/{id}/override set the max of an id, with
+//	                                            {"max": 100, "duration": "24h"}
+//	DELETE /policies/{policy}/ids/{id}/override remove the override of an id
+//
+// The duration of an override is optional. To mount the API under a prefix,
+// use http.StripPrefix:
+//
+//	admin := httpbump.NewAdminHandler(table, httpbump.BearerToken(token))
+//	mux.Handle("/ratelimits/", http.StripPrefix("/ratelimits", admin))
+func NewAdminHandler(table *PolicyTable, auth Authenticator) http.Handler {
+	a := &admin{table: table}
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /policies", a.policies)
+	mux.HandleFunc("GET /ids/{id}", a.id)
+	mux.HandleFunc("DELETE /policies/{policy}/ids/{id}/counter", a.reset)
+	mux.HandleFunc("PUT /policies/{policy}/ids/{id}/ban", a.ban)
+	mux.HandleFunc("DELETE /policies/{policy}/ids/{id}/ban", a.unban)
+	mux.HandleFunc("PUT /policies/{policy}/ids/{id}/override", a.setOverride)
+	mux.HandleFunc("DELETE /policies/{policy}/ids/{id}/override", a.removeOverride)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth == nil || !auth(r) {
+			writeError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// admin implements the endpoints of the admin API.
+type admin struct {
+	table *PolicyTable
+}
+
+// PolicyInfo describes a policy in the admin API.
+type PolicyInfo struct {
+	Name    string   `json:"name"`
+	Routes  []string `json:"routes,omitempty"`
+	Paths   []string `json:"paths,omitempty"`
+	Methods []string `json:"methods,omitempty"`
+	Period  string   `json:"period"`
+	Max     int64    `json:"max"`
+}
+
+// IDState describes the state of an id in a policy in the admin API.
+type IDState struct {
+	Policy    string `json:"policy"`
+	Has       bool   `json:"has"`
+	Attempted int64  `json:"attempted"`
+	Left      int64  `json:"left"`
+	Max       int64  `json:"max"`
+	// Override is the max set for the id by an override, if there is one.
+	Override *int64 `json:"override,omitempty"`
+	// BannedFor is how long the ban of the id lasts, if there is one.
+	BannedFor string `json:"banned_for,omitempty"`
+}
+
+// policies lists the policies of the table.
+func (a *admin) policies(w http.ResponseWriter, r *http.Request) {
+	infos := []PolicyInfo{}
+	for _, policy := range a.table.Policies() {
+		// The limiter has the current values, which differ from the policy's
+		// if they were updated at runtime.
+		limiter := a.table.Limiter(policy.Name)
+
+		infos = append(infos, PolicyInfo{
+			Name:    policy.Name,
+			Routes:  policy.Routes,
+			Paths:   policy.Paths,
+			Methods: policy.Methods,
+			Period:  limiter.Hasher().Duration().String(),
+			Max:     limiter.Max(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// id reports the state of an id in every policy.
+func (a *admin) id(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	states := []IDState{}
+
+	for _, policy := range a.table.Policies() {
+		state, err := idState(r, a.table.Limiter(policy.Name), id)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		state.Policy = policy.Name
+		states = append(states, state)
+	}
+
+	writeJSON(w, http.StatusOK, states)
+}
+
+// idState retrieves the state of an id in a limiter.
+func idState(r *http.Request, limiter *speedbump.RateLimiter, id string) (IDState, error) {
+	state := IDState{Max: limiter.Max()}
+
+	var err error
+	if state.Has, err = limiter.HasContext(r.Context(), id); err != nil {
+		return state, err
+	}
+
+	if state.Attempted, err = limiter.AttemptedContext(r.Context(), id); err != nil {
+		return state, err
+	}
+
+	if state.Left, err = limiter.LeftContext(r.Context(), id); err != nil {
+		return state, err
+	}
+
+	override, ok, err := limiter.Override(id)
+	if err != nil {
+		return state, err
+	}
+
+	if ok {
+		state.Override = &override
+	}
+
+	banned, err := limiter.Banned(id)
+	if err != nil {
+		return state, err
+	}
+
+	if banned > 0 {
+		state.BannedFor = banned.String()
+	}
+
+	return state, nil
+}
+
+// reset clears the counter of an id.
+func (a *admin) reset(w http.ResponseWriter, r *http.Request) {
+	a.update(w, r, func(limiter *speedbump.RateLimiter, id string) error {
+		return limiter.Reset(id)
+	})
+}
+
+// ban bans an id.
+func (a *admin) ban(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Duration string `json:"duration"`
+	}
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	duration, ok := parseDuration(w, body.Duration, true)
+	if !ok {
+		return
+	}
+
+	a.update(w, r, func(limiter *speedbump.RateLimiter, id string) error {
+		return limiter.Ban(id, duration)
+	})
+}
+
+// unban lifts the ban of an id.
+func (a *admin) unban(w http.ResponseWriter, r *http.Request) {
+	a.update(w, r, func(limiter *speedbump.RateLimiter, id string) error {
+		return limiter.Unban(id)
+	})
+}
+
+// setOverride sets the max of an id.
+func (a *admin) setOverride(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Max      *int64 `json:"max"`
+		Duration string `json:"duration"`
+	}
+
+	if !readJSON(w, r, &body) {
+		return
+	}
+
+	if body.Max == nil || *body.Max < 0 {
+		writeError(w, http.StatusBadRequest, "max must be a number of requests")
+		return
+	}
+
+	duration, ok := parseDuration(w, body.Duration, false)
+	if !ok {
+		return
+	}
+
+	a.update(w, r, func(limiter *speedbump.RateLimiter, id string) error {
+		return limiter.SetOverride(id, *body.Max, duration)
+	})
+}
+
+// removeOverride removes the override of an id.
+func (a *admin) removeOverride(w http.ResponseWriter, r *http.Request) {
+	a.update(w, r, func(limiter *speedbump.RateLimiter, id string) error {
+		return limiter.RemoveOverride(id)
+	})
+}
+
+// update applies a change to an id in the policy of the request, and responds
+// with the new state of the id.
+func (a *admin) update(
+	w http.ResponseWriter,
+	r *http.Request,
+	change func(limiter *speedbump.RateLimiter, id string) error,
+) {
+	name, id := r.PathValue("policy"), r.PathValue("id")
+
+	limiter := a.table.Limiter(name)
+	if limiter == nil {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown policy %q", name))
+		return
+	}
+
+	if err := change(limiter, id); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	state, err := idState(r, limiter, id)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	state.Policy = name
+	writeJSON(w, http.StatusOK, state)
+}
+
+// parseDuration parses a duration sent to the admin API. An empty duration is
+// zero, unless it is required.
+func parseDuration(w http.ResponseWriter, value string, required bool) (time.Duration, bool) {
+	if value == "" && !required {
+		return 0, true
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		writeError(w, http.StatusBadRequest, "duration must be a positive duration, such as \"1h\"")
+		return 0, false
+	}
+
+	return duration, true
+}
+
+// readJSON decodes the body of a request, responding with an error if it is
+// invalid.
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON body: "+err.Error())
+		return false
+	}
+
+	return true
+}
+
+// writeJSON writes a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response.
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
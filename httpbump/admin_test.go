@@ -0,0 +1,167 @@
+package httpbump
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBearerToken(t *testing.T) {
+	auth := BearerToken("secret", "other")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.False(t, auth(r))
+
+	r.Header.Set("Authorization", "Bearer secret")
+	assert.True(t, auth(r))
+
+	r.Header.Set("Authorization", "Bearer other")
+	assert.True(t, auth(r))
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	assert.False(t, auth(r))
+
+	r.Header.Set("Authorization", "Basic secret")
+	assert.False(t, auth(r))
+
+	assert.False(t, BearerToken("")(newAuthRequest("Bearer ")))
+}
+
+func TestBasicAuth(t *testing.T) {
+	auth := BasicAuth("admin", "hunter2")
+
+	r := httptest.NewRequest("GET", "/", nil)
+	assert.False(t, auth(r))
+
+	r.SetBasicAuth("admin", "hunter2")
+	assert.True(t, auth(r))
+
+	r.SetBasicAuth("admin", "wrong")
+	assert.False(t, auth(r))
+
+	r.SetBasicAuth("root", "hunter2")
+	assert.False(t, auth(r))
+
+	r = httptest.NewRequest("GET", "/", nil)
+	r.SetBasicAuth("admin", "")
+	assert.False(t, BasicAuth("admin", "")(r))
+}
+
+func newAuthRequest(header string) *http.Request {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", header)
+
+	return r
+}
+
+func TestAdminHandler(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	table, err := NewPolicyTable(
+		client,
+		Policy{
+			Name:    "login",
+			Routes:  []string{"POST /login"},
+			Methods: []string{"POST"},
+			Hasher:  speedbump.PerMinuteHasher{},
+			Max:     2,
+		},
+		Policy{
+			Name:   "default",
+			Hasher: speedbump.PerHourHasher{},
+			Max:    100,
+		},
+	)
+	require.NoError(t, err)
+
+	handler := NewAdminHandler(table, BearerToken("secret"))
+
+	do := func(method, target, body string) (int, string) {
+		r := httptest.NewRequest(method, target, strings.NewReader(body))
+		r.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w.Code, w.Body.String()
+	}
+
+	// Requests without credentials are rejected.
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/policies", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	w = httptest.NewRecorder()
+	NewAdminHandler(table, nil).ServeHTTP(w, httptest.NewRequest("GET", "/policies", nil))
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	code, body := do("GET", "/policies", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[
+		{"name":"login","routes":["POST /login"],"methods":["POST"],"period":"1m0s","max":2},
+		{"name":"default","period":"1h0m0s","max":100}
+	]`, body)
+
+	limiter := table.Limiter("login")
+	_, err = limiter.Attempt("1.2.3.4")
+	require.NoError(t, err)
+
+	code, body = do("GET", "/ids/1.2.3.4", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `[
+		{"policy":"login","has":true,"attempted":1,"left":1,"max":2},
+		{"policy":"default","has":false,"attempted":0,"left":100,"max":100}
+	]`, body)
+
+	code, body = do("PUT", "/policies/login/ids/1.2.3.4/override", `{"max":10}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"policy":"login","has":true,"attempted":1,"left":9,"max":2,"override":10}`, body)
+
+	code, body = do("DELETE", "/policies/login/ids/1.2.3.4/counter", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"policy":"login","has":false,"attempted":0,"left":10,"max":2,"override":10}`, body)
+
+	code, body = do("DELETE", "/policies/login/ids/1.2.3.4/override", "")
+	assert.Equal(t, http.StatusOK, code)
+	assert.JSONEq(t, `{"policy":"login","has":false,"attempted":0,"left":2,"max":2}`, body)
+
+	code, body = do("PUT", "/policies/login/ids/1.2.3.4/ban", `{"duration":"1h"}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	var state IDState
+	require.NoError(t, json.Unmarshal([]byte(body), &state))
+	assert.NotEmpty(t, state.BannedFor)
+
+	ok, err := limiter.Attempt("1.2.3.4")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	code, body = do("DELETE", "/policies/login/ids/1.2.3.4/ban", "")
+	assert.Equal(t, http.StatusOK, code)
+	state = IDState{}
+	require.NoError(t, json.Unmarshal([]byte(body), &state))
+	assert.Empty(t, state.BannedFor)
+
+	// Invalid requests.
+	code, _ = do("PUT", "/policies/missing/ids/1.2.3.4/ban", `{"duration":"1h"}`)
+	assert.Equal(t, http.StatusNotFound, code)
+
+	code, _ = do("PUT", "/policies/login/ids/1.2.3.4/ban", `{}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = do("PUT", "/policies/login/ids/1.2.3.4/override", `{"duration":"1h"}`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = do("PUT", "/policies/login/ids/1.2.3.4/override", `not json`)
+	assert.Equal(t, http.StatusBadRequest, code)
+
+	code, _ = do("POST", "/policies", "")
+	assert.Equal(t, http.StatusMethodNotAllowed, code)
+}
@@ -0,0 +1,84 @@
+package httpbump
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/etcinit/speedbump"
+)
+
+// LimitConcurrency is a net/http middleware that limits the number of requests
+// of each client that are handled at the same time. The slot taken by a
+// request is released when the handler returns, and it is kept alive while the
+// handler runs for longer than the TTL of the limiter.
+//
+// The key determines the id of the client. If it is nil, the IP address of the
+// connecting peer is used. Requests matched by any of the skippers are not
+// limited.
+//
+//	exports := speedbump.NewConcurrencyLimiter(client, 3, time.Minute)
+//	byCustomer := func(r *http.Request) string {
+//	  return r.Header.Get("X-Customer-ID")
+//	}
+//
+//	mux.Handle("POST /exports", httpbump.LimitConcurrency(exports, byCustomer)(handler))
+//
+// Once a client has too many requests in progress, they will receive a JSON
+// response similar to the following:
+//
+//	{"error":"Too many concurrent requests. Try again once a request completes"}
+func LimitConcurrency(
+	limiter *speedbump.ConcurrencyLimiter,
+	key func(r *http.Request) string,
+	skippers ...Skipper,
+) func(http.Handler) http.Handler {
+	skip := Skip(skippers...)
+	policy := &Policy{Key: key}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			lease, ok, err := limiter.Acquire(policy.ID(r))
+			if err != nil {
+				panic(err)
+			}
+
+			if !ok {
+				WriteConcurrencyLimited(w)
+				return
+			}
+
+			defer HoldLease(limiter, lease)()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// HoldLease keeps a lease alive until the returned function is called, which
+// releases it. It is meant to be deferred until a request is handled.
+func HoldLease(limiter *speedbump.ConcurrencyLimiter, lease *speedbump.Lease) (release func()) {
+	stop := lease.KeepAlive(limiter.TTL() / 2)
+
+	return func() {
+		stop()
+
+		// The lease expires anyway if it can't be released.
+		lease.Release()
+	}
+}
+
+// WriteConcurrencyLimited writes the response sent to clients that have too
+// many requests in progress.
+func WriteConcurrencyLimited(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "Too many concurrent requests. Try again once a request completes",
+	})
+}
@@ -0,0 +1,63 @@
+package httpbump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimitConcurrency(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	limiter := speedbump.NewConcurrencyLimiter(client, 1, time.Minute)
+	started := make(chan struct{})
+	finish := make(chan struct{})
+
+	handler := LimitConcurrency(limiter, nil, SkipPaths("/health"))(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				started <- struct{}{}
+				<-finish
+			}
+		}),
+	)
+
+	serve := func(target string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequest("GET", target))
+
+		return recorder.Code
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		assert.Equal(t, http.StatusOK, serve("/slow"))
+	}()
+
+	<-started
+
+	// The slot is taken while the slow request is in progress.
+	assert.Equal(t, http.StatusTooManyRequests, serve("/fast"))
+	assert.Equal(t, http.StatusOK, serve("/health"))
+
+	close(finish)
+	wg.Wait()
+
+	// The slot is released once the handler returns.
+	assert.Equal(t, http.StatusOK, serve("/fast"))
+	assert.Equal(t, http.StatusOK, serve("/fast"))
+
+	inFlight, err := limiter.InFlight("8.8.8.8")
+	require.NoError(t, err)
+	assert.Zero(t, inFlight)
+}
@@ -0,0 +1,155 @@
+package httpbump
+
+import (
+	"strings"
+)
+
+// Names of the headers commonly used by proxies and CDNs to pass along the
+// address of the client.
+const (
+	// HeaderForwarded is the standard header defined in RFC 7239.
+	HeaderForwarded = "Forwarded"
+	// HeaderXForwardedFor is the de-facto standard list of forwarding hops.
+	HeaderXForwardedFor = "X-Forwarded-For"
+	// HeaderXRealIP is set by Nginx and many ingress controllers.
+	HeaderXRealIP = "X-Real-Ip"
+	// HeaderCFConnectingIP is set by Cloudflare.
+	HeaderCFConnectingIP = "Cf-Connecting-Ip"
+	// HeaderTrueClientIP is set by Akamai and Cloudflare Enterprise.
+	HeaderTrueClientIP = "True-Client-Ip"
+	// HeaderFastlyClientIP is set by Fastly.
+	HeaderFastlyClientIP = "Fastly-Client-Ip"
+)
+
+// Provider is a preset for a CDN or load balancer. It tells a Resolver which
+// header the provider uses to pass along the address of the client.
+//
+// Presets don't include the address ranges of the provider, since those change
+// over time. Pass them, along with the addresses of any proxies of your own,
+// when creating the resolver.
+type Provider struct {
+	// Name is a short identifier for the provider, such as "cloudflare".
+	Name string
+	// Header is the header that holds the address of the client.
+	Header string
+}
+
+// Presets for common providers.
+var (
+	// Standard reads the RFC 7239 Forwarded header.
+	Standard = Provider{Name: "standard", Header: HeaderForwarded}
+	// Cloudflare reads the CF-Connecting-IP header.
+	Cloudflare = Provider{Name: "cloudflare", Header: HeaderCFConnectingIP}
+	// Fastly reads the Fastly-Client-IP header.
+	Fastly = Provider{Name: "fastly", Header: HeaderFastlyClientIP}
+	// Akamai reads the True-Client-IP header.
+	Akamai = Provider{Name: "akamai", Header: HeaderTrueClientIP}
+	// GCP reads the X-Forwarded-For header appended by Google Cloud load
+	// balancers. The load balancer appends both the client and its own address,
+	// so the address of the load balancer has to be trusted as well.
+	GCP = Provider{Name: "gcp", Header: HeaderXForwardedFor}
+	// AWSALB reads the X-Forwarded-For header appended by AWS Application Load
+	// Balancers.
+	AWSALB = Provider{Name: "aws-alb", Header: HeaderXForwardedFor}
+	// Nginx reads the X-Real-IP header commonly set by Nginx.
+	Nginx = Provider{Name: "nginx", Header: HeaderXRealIP}
+)
+
+// Providers lists all the available presets.
+var Providers = []Provider{Standard, Cloudflare, Fastly, Akamai, GCP, AWSALB, Nginx}
+
+// LookupProvider finds a preset by name.
+func LookupProvider(name string) (Provider, bool) {
+	for _, provider := range Providers {
+		if strings.EqualFold(provider.Name, name) {
+			return provider, true
+		}
+	}
+
+	return Provider{}, false
+}
+
+// Resolver creates a new Resolver that reads the provider's header and trusts
+// proxies in the provided networks.
+func (p Provider) Resolver(trustedProxies ...string) (*Resolver, error) {
+	return NewHeaderResolver(p.Header, trustedProxies...)
+}
+
+// ParseForwarded parses the value of a Forwarded header as defined in RFC 7239
+// and returns the nodes in its "for" parameters, in order. Quotes are removed,
+// but ports and brackets around IPv6 addresses are kept, so a node may look
+// like "192.0.2.43", "[2001:db8:cafe::17]:4711" or "unknown".
+//
+// Elements without a "for" parameter are returned as empty strings so that the
+// position of every hop is preserved.
+func ParseForwarded(value string) []string {
+	nodes := []string{}
+
+	for _, element := range splitQuoted(value, ',') {
+		node := ""
+
+		for _, pair := range splitQuoted(element, ';') {
+			eq := strings.IndexByte(pair, '=')
+			if eq < 0 {
+				continue
+			}
+
+			name := strings.TrimSpace(pair[:eq])
+			if strings.EqualFold(name, "for") {
+				node = unquote(strings.TrimSpace(pair[eq+1:]))
+			}
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	return nodes
+}
+
+// splitQuoted splits s around each instance of sep that is not inside a
+// quoted string.
+func splitQuoted(s string, sep byte) []string {
+	parts := []string{}
+	quoted := false
+	start := 0
+
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && quoted:
+			// Skip the escaped character.
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+
+	if rest := strings.TrimSpace(s[start:]); rest != "" || len(parts) > 0 {
+		parts = append(parts, rest)
+	}
+
+	return parts
+}
+
+// unquote removes the quotes and escapes from a quoted string. Tokens are
+// returned as they are.
+func unquote(s string) string {
+	if len(s) < 2 || s[0] != '"' || s[len(s)-1] != '"' {
+		return s
+	}
+
+	s = s[1 : len(s)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
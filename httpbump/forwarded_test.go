@@ -0,0 +1,31 @@
+package httpbump
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseForwarded(t *testing.T) {
+	assert.Equal(t, []string{"192.0.2.60"}, ParseForwarded("for=192.0.2.60;proto=http;by=203.0.113.43"))
+
+	assert.Equal(
+		t,
+		[]string{"192.0.2.43", "[2001:db8:cafe::17]:4711", "unknown"},
+		ParseForwarded(`For="192.0.2.43", for="[2001:db8:cafe::17]:4711", for=unknown`),
+	)
+
+	// Separators inside quoted strings are not treated as separators.
+	assert.Equal(t, []string{"a,b;c", ""}, ParseForwarded(`for="a,b;c", by=10.0.0.1`))
+
+	assert.Equal(t, []string{}, ParseForwarded(""))
+}
+
+func TestLookupProvider(t *testing.T) {
+	provider, ok := LookupProvider("Cloudflare")
+	assert.True(t, ok)
+	assert.Equal(t, Cloudflare, provider)
+
+	_, ok = LookupProvider("nope")
+	assert.False(t, ok)
+}
@@ -0,0 +1,78 @@
+package httpbump
+
+import (
+	"net/http"
+
+	"github.com/etcinit/speedbump"
+)
+
+// RateLimitHierarchy is a net/http middleware that limits incoming requests on
+// every level of a hierarchy, such as the user, the tenant and the whole
+// service. The ids function returns the id of the request on every level, in
+// the same order as the levels of the hierarchy. Requests matched by any of
+// the skippers are not limited.
+//
+//	ids := func(r *http.Request) []string {
+//	  return []string{r.Header.Get("X-User-ID"), r.Header.Get("X-Tenant-ID"), ""}
+//	}
+//
+//	http.ListenAndServe(":8080", httpbump.RateLimitHierarchy(hierarchy, ids)(mux))
+//
+// Rejected requests receive the same response as in RateLimit, based on the
+// hasher of the level that rejected them.
+func RateLimitHierarchy(
+	hierarchy *speedbump.Hierarchy,
+	ids func(r *http.Request) []string,
+	skippers ...Skipper,
+) func(http.Handler) http.Handler {
+	skip := Skip(skippers...)
+	levels := hierarchy.Levels()
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			decision, err := hierarchy.Attempt(r.Context(), ids(r)...)
+			if err != nil {
+				panic(err)
+			}
+
+			// Levels that didn't reject an attempt that was rejected were not
+			// evaluated, so they are left out of the span.
+			for i, level := range levels {
+				if decision.RejectedBy == "" || level.Name == decision.RejectedBy {
+					speedbump.AddDecisionEvent(r.Context(), level.Limiter, decision.Levels[i].Allowed)
+				}
+			}
+
+			if !decision.Allowed {
+				WriteLimited(w, rejectedBy(levels, decision).Hasher())
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// rejectedBy returns the limiter of the level that rejected an attempt. If the
+// attempt was denied by a failure mode instead, it returns the first limiter
+// that denied it.
+func rejectedBy(levels []speedbump.Level, decision speedbump.HierarchyDecision) *speedbump.RateLimiter {
+	for _, level := range levels {
+		if level.Name == decision.RejectedBy {
+			return level.Limiter
+		}
+	}
+
+	for i, level := range levels {
+		if !decision.Levels[i].Allowed {
+			return level.Limiter
+		}
+	}
+
+	return levels[0].Limiter
+}
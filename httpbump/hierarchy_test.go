@@ -0,0 +1,72 @@
+package httpbump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitHierarchy(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	hierarchy, err := speedbump.NewHierarchy(
+		speedbump.Level{
+			Name:    "user",
+			Limiter: speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 2, speedbump.WithNamespace("user")),
+		},
+		speedbump.Level{
+			Name:    "tenant",
+			Limiter: speedbump.NewLimiter(client, speedbump.PerMinuteHasher{}, 3, speedbump.WithNamespace("tenant")),
+		},
+	)
+	require.NoError(t, err)
+
+	ids := func(r *http.Request) []string {
+		return []string{r.Header.Get("X-User"), r.Header.Get("X-Tenant")}
+	}
+	handler := RateLimitHierarchy(hierarchy, ids)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+	)
+
+	serve := func(user string) int {
+		request := newRequest("GET", "/")
+		request.Header.Set("X-User", user)
+		request.Header.Set("X-Tenant", "acme")
+
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("alice"))
+	assert.Equal(t, http.StatusOK, serve("alice"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("alice"))
+	assert.Equal(t, http.StatusOK, serve("bob"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("bob"))
+}
+
+func TestRejectedBy(t *testing.T) {
+	users := speedbump.NewLimiter(nil, speedbump.PerSecondHasher{}, 1)
+	tenants := speedbump.NewLimiter(nil, speedbump.PerMinuteHasher{}, 1)
+	levels := []speedbump.Level{{Name: "user", Limiter: users}, {Name: "tenant", Limiter: tenants}}
+
+	// Levels before the one that rejected the attempt are not allowed either.
+	decision := speedbump.HierarchyDecision{
+		RejectedBy: "tenant",
+		Levels:     []speedbump.Decision{{Remaining: 1}, {Limited: true}},
+	}
+	assert.Same(t, tenants, rejectedBy(levels, decision))
+
+	// Attempts denied by a failure mode report the first level that denied them.
+	decision = speedbump.HierarchyDecision{
+		Levels: []speedbump.Decision{{Allowed: true}, {}},
+	}
+	assert.Same(t, tenants, rejectedBy(levels, decision))
+}
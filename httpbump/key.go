@@ -0,0 +1,57 @@
+package httpbump
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DefaultIPv6PrefixLength is the prefix length used by IPKey to aggregate IPv6
+// addresses. Clients are usually assigned a whole /64 (or more), so counting
+// each address separately would let a single client rotate through an almost
+// unlimited number of counters.
+//
+// Use Resolver.WithIPv6Prefix or NormalizeIP for a different length.
+const DefaultIPv6PrefixLength = 64
+
+// IPKey normalizes an IP address so that it can be used as the id of a rate
+// limiter. It is equivalent to calling NormalizeIP with
+// DefaultIPv6PrefixLength.
+func IPKey(addr string) string {
+	return NormalizeIP(addr, DefaultIPv6PrefixLength)
+}
+
+// NormalizeIP normalizes an IP address so that every textual form of the same
+// address results in the same key:
+//
+//   - IPv4 and IPv4-mapped IPv6 addresses are written in dotted decimal form,
+//     so "::ffff:1.2.3.4" becomes "1.2.3.4".
+//   - IPv6 addresses are truncated to the provided prefix length and written
+//     in CIDR notation, so "2001:db8::1" becomes "2001:db8::/64" for a prefix
+//     of 64. A prefix of 128 (or less than 1) keeps the full address.
+//   - Zones are removed, so "fe80::1%eth0" is the same as "fe80::1".
+//
+// Values that are not IP addresses are returned unchanged.
+func NormalizeIP(addr string, ipv6Prefix int) string {
+	host := addr
+	if zone := strings.IndexByte(host, '%'); zone >= 0 {
+		host = host[:zone]
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return addr
+	}
+
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.String()
+	}
+
+	if ipv6Prefix < 1 || ipv6Prefix >= 128 {
+		return ip.String()
+	}
+
+	masked := ip.Mask(net.CIDRMask(ipv6Prefix, 128))
+
+	return masked.String() + "/" + strconv.Itoa(ipv6Prefix)
+}
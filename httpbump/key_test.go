@@ -0,0 +1,26 @@
+package httpbump
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeIP(t *testing.T) {
+	assert.Equal(t, "1.2.3.4", NormalizeIP("1.2.3.4", 64))
+	assert.Equal(t, "1.2.3.4", NormalizeIP("::ffff:1.2.3.4", 64))
+	assert.Equal(t, "2001:db8::/64", NormalizeIP("2001:DB8::1", 64))
+	assert.Equal(t, "2001:db8::/64", NormalizeIP("2001:0db8:0000:0000:ffff::1", 64))
+	assert.Equal(t, "2001:db8:0:ff00::/56", NormalizeIP("2001:db8:0:ffff::1", 56))
+	assert.Equal(t, "2001:db8::1", NormalizeIP("2001:db8::1", 128))
+	assert.Equal(t, "2001:db8::1", NormalizeIP("2001:db8::1", 0))
+	assert.Equal(t, "fe80::/64", NormalizeIP("fe80::1%eth0", 64))
+	assert.Equal(t, "unknown", NormalizeIP("unknown", 64))
+	assert.Equal(t, "", NormalizeIP("", 64))
+}
+
+func TestIPKey(t *testing.T) {
+	assert.Equal(t, "2001:db8::/64", IPKey("2001:db8::1"))
+	assert.Equal(t, IPKey("2001:db8::1"), IPKey("2001:db8::2"))
+	assert.NotEqual(t, IPKey("2001:db8::1"), IPKey("2001:db8:0:1::1"))
+}
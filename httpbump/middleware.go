@@ -0,0 +1,107 @@
+package httpbump
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/etcinit/speedbump"
+)
+
+// RateLimit is a net/http middleware that limits incoming requests according
+// to a table of policies. Requests that don't match any policy, or that are
+// matched by any of the skippers, are not limited.
+//
+// Policies are matched against the pattern of the route that handles the
+// request. When the middleware wraps a *http.ServeMux, the pattern is looked
+// up in the mux. Otherwise, the middleware should be used inside the mux, so
+// that http.Request.Pattern is set.
+//
+//	table, err := httpbump.NewPolicyTable(client,
+//	  httpbump.Policy{
+//	    Name:   "login",
+//	    Routes: []string{"POST /login"},
+//	    Hasher: speedbump.PerMinuteHasher{},
+//	    Max:    5,
+//	  },
+//	  httpbump.Policy{
+//	    Name:   "default",
+//	    Hasher: speedbump.PerMinuteHasher{},
+//	    Max:    100,
+//	  },
+//	)
+//
+//	http.ListenAndServe(":8080", httpbump.RateLimit(table)(mux))
+//
+// Once a client reaches the limit of a policy, they will receive a JSON
+// response similar to the following:
+//
+//	{"error":"Rate limit exceeded. Try again in 1 minute from now"}
+//
+// Attempts of requests whose response status matches Policy.RefundWhen are
+// refunded once the handler returns. Policies with CountWhen only count the
+// requests it selects, once they are handled.
+func RateLimit(table *PolicyTable, skippers ...Skipper) func(http.Handler) http.Handler {
+	skip := Skip(skippers...)
+
+	return func(next http.Handler) http.Handler {
+		mux, _ := next.(*http.ServeMux)
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if skip(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			policy, limiter := table.Match(r, Route(r, mux))
+			if policy == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id := policy.ID(r)
+
+			decision, err := policy.Decide(r.Context(), limiter, id)
+			if err != nil {
+				panic(err)
+			}
+
+			speedbump.AddDecisionEvent(r.Context(), limiter, decision.Allowed)
+
+			if !decision.Allowed {
+				WriteLimited(w, limiter.Hasher())
+				return
+			}
+
+			if decision.Shadowed() && policy.ShadowHeader != "" {
+				w.Header().Set(policy.ShadowHeader, policy.Name)
+			}
+
+			if policy.CountWhen == nil && (policy.RefundWhen == nil || !decision.Refundable()) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			r = policy.WithCountFlag(r)
+			recorder := &statusRecorder{ResponseWriter: w}
+			next.ServeHTTP(recorder, r)
+
+			// The response was already sent, so a failed refund or count is
+			// ignored.
+			policy.Settle(r, limiter, id, decision, recorder.Status())
+		})
+	}
+}
+
+// WriteLimited writes the response sent to clients that exceeded a limit.
+func WriteLimited(w http.ResponseWriter, hasher speedbump.RateHasher) {
+	nextTime := time.Now().Add(hasher.Duration())
+
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": "Rate limit exceeded. Try again in " + humanize.Time(nextTime),
+	})
+}
@@ -0,0 +1,115 @@
+package httpbump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/redis.v5"
+)
+
+func createClient() *redis.Client {
+	if os.Getenv("WERCKER_REDIS_HOST") != "" {
+		return redis.NewClient(&redis.Options{
+			Addr:     os.Getenv("WERCKER_REDIS_HOST") + ":6379",
+			Password: "",
+			DB:       0,
+		})
+	}
+
+	return redis.NewClient(&redis.Options{
+		Addr:     "localhost:6379",
+		Password: "",
+		DB:       0,
+	})
+}
+
+func teardown(t *testing.T, client *redis.Client) {
+	// Flush Redis.
+	require.NoError(t, client.FlushAll().Err())
+}
+
+func TestRateLimit(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	table, err := NewPolicyTable(
+		client,
+		Policy{
+			Name:   "login",
+			Routes: []string{"POST /login"},
+			Hasher: speedbump.PerMinuteHasher{},
+			Max:    1,
+		},
+		Policy{
+			Name:   "default",
+			Hasher: speedbump.PerMinuteHasher{},
+			Max:    2,
+		},
+	)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /search", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("GET /health", func(w http.ResponseWriter, r *http.Request) {})
+
+	handler := RateLimit(table, SkipPaths("/health"))(mux)
+
+	serve := func(method, target string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequest(method, target))
+
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, serve("POST", "/login"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("POST", "/login"))
+
+	// The default policy keeps a separate counter.
+	assert.Equal(t, http.StatusOK, serve("GET", "/search"))
+	assert.Equal(t, http.StatusOK, serve("GET", "/search"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("GET", "/search"))
+
+	// Skipped requests are never limited.
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve("GET", "/health"))
+	}
+}
+
+func TestRateLimitShadow(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	table, err := NewPolicyTable(
+		client,
+		Policy{
+			Name:         "search",
+			Hasher:       speedbump.PerMinuteHasher{},
+			Max:          1,
+			Shadow:       true,
+			ShadowHeader: "X-RateLimit-Shadow",
+		},
+	)
+	require.NoError(t, err)
+	assert.True(t, table.Limiter("search").Shadow())
+
+	handler := RateLimit(table)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest("GET", "/search"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Empty(t, recorder.Header().Get("X-RateLimit-Shadow"))
+
+	// Requests over the limit are allowed, but marked.
+	recorder = httptest.NewRecorder()
+	handler.ServeHTTP(recorder, newRequest("GET", "/search"))
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, "search", recorder.Header().Get("X-RateLimit-Shadow"))
+}
@@ -0,0 +1,95 @@
+package httpbump
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/etcinit/speedbump"
+)
+
+// countFlagKey is the context key of the flag set by MarkCounted.
+type countFlagKey struct{}
+
+// CountStatuses counts the requests whose response has any of the provided
+// statuses. It can be used as Policy.CountWhen.
+func CountStatuses(statuses ...int) func(r *http.Request, status int) bool {
+	return func(r *http.Request, status int) bool {
+		for _, candidate := range statuses {
+			if candidate == status {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// CountMarked counts the requests marked by their handler with MarkCounted. It
+// can be used as Policy.CountWhen.
+func CountMarked(r *http.Request, status int) bool {
+	flag, ok := r.Context().Value(countFlagKey{}).(*atomic.Bool)
+
+	return ok && flag.Load()
+}
+
+// MarkCounted marks a request to be counted by a policy that uses CountMarked,
+// such as when the credentials of a login are wrong. It has no effect on
+// requests that are not limited by such a policy.
+func MarkCounted(r *http.Request) {
+	if flag, ok := r.Context().Value(countFlagKey{}).(*atomic.Bool); ok {
+		flag.Store(true)
+	}
+}
+
+// Decide makes the decision for a request before it is handled. If the policy
+// has CountWhen, the request is only checked against the limit. Otherwise, it
+// is counted.
+func (p *Policy) Decide(
+	ctx context.Context,
+	limiter *speedbump.RateLimiter,
+	id string,
+) (speedbump.Decision, error) {
+	if p.CountWhen != nil {
+		return limiter.Check(ctx, id)
+	}
+
+	return limiter.Decide(ctx, id)
+}
+
+// Settle completes the decision for a request once it is handled, according to
+// the status of the response: the request is counted if it is selected by
+// CountWhen, and its attempt is refunded if it is selected by RefundWhen.
+// Middleware use it together with Decide.
+func (p *Policy) Settle(
+	r *http.Request,
+	limiter *speedbump.RateLimiter,
+	id string,
+	decision speedbump.Decision,
+	status int,
+) error {
+	if p.CountWhen != nil {
+		if !p.CountWhen(r, status) {
+			return nil
+		}
+
+		return limiter.Count(r.Context(), id)
+	}
+
+	if p.RefundWhen != nil && p.RefundWhen(status) {
+		return limiter.Refund(r.Context(), decision)
+	}
+
+	return nil
+}
+
+// WithCountFlag prepares a request so that its handler can mark it with
+// MarkCounted. Middleware that limit requests with a policy that has
+// CountWhen call it before handling the request.
+func (p *Policy) WithCountFlag(r *http.Request) *http.Request {
+	if p.CountWhen == nil {
+		return r
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), countFlagKey{}, &atomic.Bool{}))
+}
@@ -0,0 +1,96 @@
+package httpbump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountStatuses(t *testing.T) {
+	count := CountStatuses(http.StatusUnauthorized, http.StatusForbidden)
+	r := newRequest("POST", "/login")
+
+	assert.True(t, count(r, http.StatusUnauthorized))
+	assert.True(t, count(r, http.StatusForbidden))
+	assert.False(t, count(r, http.StatusOK))
+}
+
+func TestCountMarked(t *testing.T) {
+	r := newRequest("POST", "/login")
+
+	// Requests without the flag can't be marked.
+	MarkCounted(r)
+	assert.False(t, CountMarked(r, http.StatusOK))
+
+	policy := &Policy{CountWhen: CountMarked}
+	r = policy.WithCountFlag(r)
+	assert.False(t, CountMarked(r, http.StatusOK))
+
+	MarkCounted(r)
+	assert.True(t, CountMarked(r, http.StatusOK))
+}
+
+func TestRateLimitCountWhen(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	table, err := NewPolicyTable(
+		client,
+		Policy{
+			Name:      "login",
+			Routes:    []string{"POST /login"},
+			Hasher:    speedbump.PerMinuteHasher{},
+			Max:       2,
+			CountWhen: CountStatuses(http.StatusUnauthorized),
+		},
+		Policy{
+			Name:      "otp",
+			Routes:    []string{"POST /otp"},
+			Hasher:    speedbump.PerMinuteHasher{},
+			Max:       1,
+			CountWhen: CountMarked,
+		},
+	)
+	require.NoError(t, err)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /login", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("password") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	})
+	mux.HandleFunc("POST /otp", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("code") != "123456" {
+			MarkCounted(r)
+		}
+	})
+
+	handler := RateLimit(table)(mux)
+
+	serve := func(target string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequest("POST", target))
+
+		return recorder.Code
+	}
+
+	// Successful logins pass freely.
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusOK, serve("/login?password=secret"))
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, serve("/login?password=wrong"))
+	assert.Equal(t, http.StatusUnauthorized, serve("/login?password=wrong"))
+
+	// Once the limit of failures is reached, every login is rejected.
+	assert.Equal(t, http.StatusTooManyRequests, serve("/login?password=secret"))
+
+	assert.Equal(t, http.StatusOK, serve("/otp?code=123456"))
+	assert.Equal(t, http.StatusOK, serve("/otp?code=000000"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/otp?code=123456"))
+}
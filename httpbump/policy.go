@@ -0,0 +1,178 @@
+package httpbump
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/etcinit/speedbump"
+	"gopkg.in/redis.v5"
+)
+
+// Policy is a rate limit that applies to a subset of the requests handled by a
+// server, such as "5 requests per minute to POST /login".
+type Policy struct {
+	// Name identifies the policy. It is also used as the namespace of the
+	// policy's counters, so it must be unique within a PolicyTable.
+	Name string
+	// Routes are the route patterns the policy applies to. They are compared
+	// to the pattern of the route that matched the request, such as
+	// "/users/:id" in Gin or "GET /users/{id}" in net/http.
+	Routes []string
+	// Paths are patterns matched against the path of the request. See
+	// SkipPaths for the syntax.
+	Paths []string
+	// Methods are the HTTP methods the policy applies to.
+	Methods []string
+	// Hasher defines the period of the policy.
+	Hasher speedbump.RateHasher
+	// Max is the maximum number of requests allowed during a period.
+	Max int64
+	// Key determines the id of the client. If it is not provided, the IP
+	// address of the connecting peer is used.
+	Key func(r *http.Request) string
+	// Options are passed to the limiter of the policy, after the namespace.
+	Options []speedbump.Option
+	// Shadow puts the limiter of the policy in shadow mode, so that requests
+	// over the limit are reported but not rejected. See
+	// speedbump.WithShadowMode.
+	Shadow bool
+	// ShadowHeader is a response header that is set to the name of the policy
+	// on requests that were only allowed because of shadow mode, if provided.
+	ShadowHeader string
+	// RefundWhen decides, from the status of the response, whether the attempt
+	// of a request should be refunded once it is handled, such as
+	// RefundServerErrors. If it is nil, attempts are never refunded.
+	RefundWhen func(status int) bool
+	// CountWhen decides, once a request is handled, whether it counts towards
+	// the limit, such as CountStatuses(401, 403) to only count failed logins.
+	// Requests are still checked against the limit before they are handled.
+	// If it is nil, every request is counted before it is handled.
+	CountWhen func(r *http.Request, status int) bool
+}
+
+// Matches returns whether the policy applies to a request. A policy without
+// routes and paths matches any route, and a policy without methods matches any
+// method.
+func (p *Policy) Matches(r *http.Request, route string) bool {
+	if len(p.Methods) > 0 && !SkipMethods(p.Methods...)(r) {
+		return false
+	}
+
+	if len(p.Routes) == 0 && len(p.Paths) == 0 {
+		return true
+	}
+
+	for _, pattern := range p.Routes {
+		if pattern == route {
+			return true
+		}
+	}
+
+	return SkipPaths(p.Paths...)(r)
+}
+
+// ID returns the id of the client that made the request according to the
+// policy.
+func (p *Policy) ID(r *http.Request) string {
+	if p.Key != nil {
+		return p.Key(r)
+	}
+
+	return peerResolver.Key(r)
+}
+
+// PolicyTable is an ordered list of policies. A request is limited by the first
+// policy that matches it, so more specific policies should come first, and a
+// policy without routes can be used as a catch-all at the end.
+type PolicyTable struct {
+	policies []*Policy
+	limiters []*speedbump.RateLimiter
+}
+
+// NewPolicyTable creates a table of policies that use the provided client to
+// talk to the Redis server. An error is returned if any of the policies is
+// invalid.
+func NewPolicyTable(client *redis.Client, policies ...Policy) (*PolicyTable, error) {
+	table := &PolicyTable{}
+	names := map[string]bool{}
+
+	for i := range policies {
+		policy := policies[i]
+
+		switch {
+		case policy.Name == "":
+			return nil, fmt.Errorf("httpbump: policy #%d has no name", i)
+		case names[policy.Name]:
+			return nil, fmt.Errorf("httpbump: duplicate policy name %q", policy.Name)
+		case policy.Hasher == nil:
+			return nil, fmt.Errorf("httpbump: policy %q has no hasher", policy.Name)
+		case policy.Max < 1:
+			return nil, fmt.Errorf("httpbump: policy %q must allow at least one request", policy.Name)
+		}
+
+		names[policy.Name] = true
+
+		table.policies = append(table.policies, &policy)
+		options := append([]speedbump.Option{speedbump.WithNamespace(policy.Name)}, policy.Options...)
+		if policy.Shadow {
+			options = append(options, speedbump.WithShadowMode())
+		}
+
+		table.limiters = append(table.limiters, speedbump.NewLimiter(
+			client,
+			policy.Hasher,
+			policy.Max,
+			options...,
+		))
+	}
+
+	if len(table.policies) == 0 {
+		return nil, errors.New("httpbump: no policies provided")
+	}
+
+	return table, nil
+}
+
+// Match finds the first policy that applies to the request, along with its
+// limiter. If no policy matches, the request is not limited and nil is
+// returned.
+func (t *PolicyTable) Match(r *http.Request, route string) (*Policy, *speedbump.RateLimiter) {
+	for i, policy := range t.policies {
+		if policy.Matches(r, route) {
+			return policy, t.limiters[i]
+		}
+	}
+
+	return nil, nil
+}
+
+// Policies returns the policies in the table, in order.
+func (t *PolicyTable) Policies() []*Policy {
+	return t.policies
+}
+
+// Limiter returns the limiter of the policy with the provided name, or nil if
+// there is no such policy.
+func (t *PolicyTable) Limiter(name string) *speedbump.RateLimiter {
+	for i, policy := range t.policies {
+		if policy.Name == name {
+			return t.limiters[i]
+		}
+	}
+
+	return nil
+}
+
+// Route returns the pattern of the route that matched the request in a
+// net/http server. Requests that have not been routed yet are looked up in the
+// mux, if one is provided.
+func Route(r *http.Request, mux *http.ServeMux) string {
+	if r.Pattern != "" || mux == nil {
+		return r.Pattern
+	}
+
+	_, pattern := mux.Handler(r)
+
+	return pattern
+}
@@ -0,0 +1,86 @@
+package httpbump
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicyMatches(t *testing.T) {
+	login := Policy{Routes: []string{"/login"}, Methods: []string{"POST"}}
+	assert.True(t, login.Matches(newRequest("POST", "/login"), "/login"))
+	assert.False(t, login.Matches(newRequest("GET", "/login"), "/login"))
+	assert.False(t, login.Matches(newRequest("POST", "/logout"), "/logout"))
+
+	users := Policy{Routes: []string{"/users/:id"}, Paths: []string{"/admin/**"}}
+	assert.True(t, users.Matches(newRequest("GET", "/users/1"), "/users/:id"))
+	assert.True(t, users.Matches(newRequest("GET", "/admin/users"), ""))
+	assert.False(t, users.Matches(newRequest("GET", "/users/1"), ""))
+
+	all := Policy{}
+	assert.True(t, all.Matches(newRequest("DELETE", "/anything"), ""))
+}
+
+func TestPolicyID(t *testing.T) {
+	policy := Policy{}
+	assert.Equal(t, "8.8.8.8", policy.ID(newRequest("GET", "/")))
+
+	policy.Key = func(r *http.Request) string { return r.Header.Get("X-Api-Key") }
+	request := newRequest("GET", "/")
+	request.Header.Set("X-Api-Key", "key")
+	assert.Equal(t, "key", policy.ID(request))
+}
+
+func TestNewPolicyTable(t *testing.T) {
+	hasher := speedbump.PerMinuteHasher{}
+
+	_, err := NewPolicyTable(nil)
+	assert.Error(t, err)
+
+	_, err = NewPolicyTable(nil, Policy{Hasher: hasher, Max: 1})
+	assert.Error(t, err)
+
+	_, err = NewPolicyTable(nil, Policy{Name: "a", Max: 1})
+	assert.Error(t, err)
+
+	_, err = NewPolicyTable(nil, Policy{Name: "a", Hasher: hasher})
+	assert.Error(t, err)
+
+	_, err = NewPolicyTable(
+		nil,
+		Policy{Name: "a", Hasher: hasher, Max: 1},
+		Policy{Name: "a", Hasher: hasher, Max: 1},
+	)
+	assert.Error(t, err)
+
+	table, err := NewPolicyTable(
+		nil,
+		Policy{Name: "login", Routes: []string{"/login"}, Hasher: hasher, Max: 5},
+		Policy{Name: "default", Hasher: hasher, Max: 100},
+	)
+	require.NoError(t, err)
+	assert.Len(t, table.Policies(), 2)
+
+	policy, limiter := table.Match(newRequest("GET", "/login"), "/login")
+	require.NotNil(t, policy)
+	assert.Equal(t, "login", policy.Name)
+	assert.Equal(t, "login", limiter.Namespace())
+	assert.Equal(t, int64(5), limiter.Max())
+
+	policy, limiter = table.Match(newRequest("GET", "/search"), "/search")
+	require.NotNil(t, policy)
+	assert.Equal(t, "default", policy.Name)
+	assert.Equal(t, limiter, table.Limiter("default"))
+	assert.Nil(t, table.Limiter("nope"))
+}
+
+func TestRoute(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {})
+
+	assert.Equal(t, "GET /users/{id}", Route(newRequest("GET", "/users/1"), mux))
+	assert.Equal(t, "", Route(newRequest("GET", "/users/1"), nil))
+}
@@ -0,0 +1,49 @@
+package httpbump
+
+import "net/http"
+
+// RefundServerErrors refunds the attempts of requests that failed because of
+// the server, with a 5xx status. It can be used as Policy.RefundWhen.
+func RefundServerErrors(status int) bool {
+	return status >= http.StatusInternalServerError
+}
+
+// statusRecorder records the status of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status and sends it.
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write sends data, which implies a 200 status if none was sent.
+func (w *statusRecorder) Write(data []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	return w.ResponseWriter.Write(data)
+}
+
+// Status returns the status of the response, which is 200 if the handler
+// didn't send any.
+func (w *statusRecorder) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+
+	return w.status
+}
+
+// Unwrap returns the original writer, so that http.ResponseController can
+// access its other features.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,62 @@
+package httpbump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefundServerErrors(t *testing.T) {
+	assert.False(t, RefundServerErrors(http.StatusOK))
+	assert.False(t, RefundServerErrors(http.StatusNotFound))
+	assert.True(t, RefundServerErrors(http.StatusInternalServerError))
+	assert.True(t, RefundServerErrors(http.StatusServiceUnavailable))
+}
+
+func TestRateLimitRefund(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	table, err := NewPolicyTable(
+		client,
+		Policy{
+			Name:       "search",
+			Hasher:     speedbump.PerMinuteHasher{},
+			Max:        2,
+			RefundWhen: RefundServerErrors,
+		},
+	)
+	require.NoError(t, err)
+
+	handler := RateLimit(table)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		case "/missing":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+
+	serve := func(target string) int {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, newRequest("GET", target))
+
+		return recorder.Code
+	}
+
+	// Server errors don't use up the limit.
+	for i := 0; i < 5; i++ {
+		assert.Equal(t, http.StatusBadGateway, serve("/broken"))
+	}
+
+	assert.Equal(t, http.StatusOK, serve("/search"))
+	assert.Equal(t, http.StatusNotFound, serve("/missing"))
+	assert.Equal(t, http.StatusTooManyRequests, serve("/search"))
+}
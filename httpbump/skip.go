@@ -0,0 +1,161 @@
+package httpbump
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Skipper decides whether a request is exempt from rate limiting. Exempt
+// requests are not counted and never reach the Redis server.
+type Skipper func(r *http.Request) bool
+
+// Skip combines multiple skippers into one that exempts a request if any of
+// them does.
+func Skip(skippers ...Skipper) Skipper {
+	return func(r *http.Request) bool {
+		for _, skip := range skippers {
+			if skip != nil && skip(r) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// SkipPaths exempts requests whose path matches any of the provided patterns.
+// Patterns use the syntax of path.Match, so "/health" matches a single path and
+// "/static/*" matches any file in /static. Additionally, a pattern ending in
+// "/**" matches every path under its prefix, such as "/internal/**".
+func SkipPaths(patterns ...string) Skipper {
+	return func(r *http.Request) bool {
+		for _, pattern := range patterns {
+			if MatchPath(pattern, r.URL.Path) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// MatchPath reports whether the path matches the pattern. See SkipPaths for the
+// pattern syntax.
+func MatchPath(pattern, p string) bool {
+	if prefix := strings.TrimSuffix(pattern, "/**"); prefix != pattern {
+		return p == prefix || strings.HasPrefix(p, prefix+"/")
+	}
+
+	matched, err := path.Match(pattern, p)
+
+	return err == nil && matched
+}
+
+// SkipMethods exempts requests made with any of the provided HTTP methods, such
+// as "OPTIONS" or "HEAD".
+func SkipMethods(methods ...string) Skipper {
+	return func(r *http.Request) bool {
+		for _, method := range methods {
+			if strings.EqualFold(method, r.Method) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// SkipNetworks exempts requests from clients in any of the provided networks,
+// which can be written in CIDR notation or as single IP addresses.
+//
+// The address of the client is determined by the resolver. If the resolver is
+// nil, the address of the connecting peer is used.
+func SkipNetworks(resolver *Resolver, networks ...string) (Skipper, error) {
+	allowed, err := ParseNetworks(networks...)
+	if err != nil {
+		return nil, err
+	}
+
+	if resolver == nil {
+		resolver = peerResolver
+	}
+
+	return func(r *http.Request) bool {
+		ip := ParseRemoteAddr(resolver.Resolve(r))
+		if ip == nil {
+			return false
+		}
+
+		for _, network := range allowed {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+
+		return false
+	}, nil
+}
+
+// SkipSigned exempts requests that carry a valid exemption signature in the
+// provided header. Internal callers create the signature with SignExemption
+// using the same secret.
+//
+// Signatures are only valid for maxAge after they are created, which limits
+// how long a leaked signature can be reused.
+func SkipSigned(header string, secret []byte, maxAge time.Duration) Skipper {
+	return func(r *http.Request) bool {
+		return VerifyExemption(r.Header.Get(header), secret, maxAge, time.Now())
+	}
+}
+
+// SignExemption creates an exemption signature for the provided time. The
+// signature has the form "<unix timestamp>.<hex encoded HMAC-SHA256>".
+func SignExemption(secret []byte, now time.Time) string {
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	return timestamp + "." + hex.EncodeToString(exemptionMAC(secret, timestamp))
+}
+
+// VerifyExemption returns whether the signature was created with the secret no
+// more than maxAge before now.
+func VerifyExemption(signature string, secret []byte, maxAge time.Duration, now time.Time) bool {
+	dot := strings.IndexByte(signature, '.')
+	if dot < 0 || len(secret) == 0 {
+		return false
+	}
+
+	timestamp, encoded := signature[:dot], signature[dot+1:]
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return false
+	}
+
+	// Reject old signatures, and signatures too far in the future to be
+	// explained by clock skew.
+	age := now.Sub(time.Unix(unix, 0))
+	if age > maxAge || age < -maxAge {
+		return false
+	}
+
+	mac, err := hex.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+
+	return hmac.Equal(mac, exemptionMAC(secret, timestamp))
+}
+
+// exemptionMAC computes the HMAC of a timestamp.
+func exemptionMAC(secret []byte, timestamp string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+
+	return mac.Sum(nil)
+}
@@ -0,0 +1,90 @@
+package httpbump
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newRequest(method, target string) *http.Request {
+	request, _ := http.NewRequest(method, target, nil)
+	request.RemoteAddr = "8.8.8.8:30475"
+
+	return request
+}
+
+func TestSkipPaths(t *testing.T) {
+	skip := SkipPaths("/health", "/static/*", "/internal/**")
+
+	assert.True(t, skip(newRequest("GET", "/health")))
+	assert.True(t, skip(newRequest("GET", "/static/app.js")))
+	assert.False(t, skip(newRequest("GET", "/static/js/app.js")))
+	assert.True(t, skip(newRequest("GET", "/internal")))
+	assert.True(t, skip(newRequest("GET", "/internal/a/b")))
+	assert.False(t, skip(newRequest("GET", "/internals")))
+	assert.False(t, skip(newRequest("GET", "/login")))
+}
+
+func TestSkipMethods(t *testing.T) {
+	skip := SkipMethods("options", "HEAD")
+
+	assert.True(t, skip(newRequest("OPTIONS", "/")))
+	assert.True(t, skip(newRequest("HEAD", "/")))
+	assert.False(t, skip(newRequest("GET", "/")))
+}
+
+func TestSkipNetworks(t *testing.T) {
+	skip, err := SkipNetworks(nil, "8.8.8.0/24")
+	require.NoError(t, err)
+	assert.True(t, skip(newRequest("GET", "/")))
+
+	request := newRequest("GET", "/")
+	request.RemoteAddr = "10.0.0.1:30475"
+	request.Header.Set("X-Forwarded-For", "8.8.8.8")
+	assert.False(t, skip(request))
+
+	// With a resolver, the forwarded address is checked instead.
+	resolver, err := NewResolver("10.0.0.0/8")
+	require.NoError(t, err)
+	skip, err = SkipNetworks(resolver, "8.8.8.8")
+	require.NoError(t, err)
+	assert.True(t, skip(request))
+
+	_, err = SkipNetworks(nil, "nope")
+	assert.Error(t, err)
+}
+
+func TestSkipSigned(t *testing.T) {
+	secret := []byte("secret")
+	skip := SkipSigned("X-Exempt", secret, time.Minute)
+
+	request := newRequest("GET", "/")
+	assert.False(t, skip(request))
+
+	request.Header.Set("X-Exempt", SignExemption(secret, time.Now()))
+	assert.True(t, skip(request))
+
+	request.Header.Set("X-Exempt", SignExemption([]byte("other"), time.Now()))
+	assert.False(t, skip(request))
+
+	request.Header.Set("X-Exempt", SignExemption(secret, time.Now().Add(-time.Hour)))
+	assert.False(t, skip(request))
+
+	request.Header.Set("X-Exempt", "garbage")
+	assert.False(t, skip(request))
+}
+
+func TestSkip(t *testing.T) {
+	skip := Skip(
+		SkipMethods("OPTIONS"),
+		nil,
+		func(r *http.Request) bool { return r.URL.Query().Get("debug") == "1" },
+	)
+
+	assert.True(t, skip(newRequest("OPTIONS", "/")))
+	assert.True(t, skip(newRequest("GET", "/?debug=1")))
+	assert.False(t, skip(newRequest("GET", "/")))
+}
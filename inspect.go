@@ -0,0 +1,119 @@
+package speedbump
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Counter is the counter of an id during the current period.
+type Counter struct {
+	// ID is the id the counter belongs to.
+	ID string `json:"id"`
+	// Attempted is the number of attempts made by the id.
+	Attempted int64 `json:"attempted"`
+}
+
+// ErrNoNamespace is returned by Counters for limiters without a namespace.
+var ErrNoNamespace = errors.New("speedbump: counters can only be listed for limiters with a namespace")
+
+// Counters returns the counters of the current period, sorted from most to
+// least attempted. At most limit counters are returned, or all of them if
+// limit is zero.
+//
+// Counters scans the whole keyspace of the Redis server, so it is meant for
+// inspecting a limiter, not for use while handling requests. It only works
+// with hashers that append the period to the id, like the built-in ones.
+//
+// The limiter must have a namespace, since the counters of a limiter without
+// one can't be told apart from the ones of other limiters that use the same
+// Redis database. ErrNoNamespace is returned otherwise.
+func (r *RateLimiter) Counters(limit int) ([]Counter, error) {
+	if r.namespace == "" {
+		return nil, ErrNoNamespace
+	}
+
+	prefix := r.namespace + ":"
+
+	// Hashing an empty id results in the part of the key that identifies the
+	// current period.
+	suffix := r.Hasher().Hash("")
+	if r.serverTime {
+		window, _, err := r.serverWindow(r.Hasher())
+		if err != nil {
+			return nil, err
+		}
+
+		suffix = window
+	}
+	pattern := escapePattern(prefix) + "*" + escapePattern(suffix)
+
+	keys := []string{}
+	iterator := r.redisClient.Scan(0, pattern, 1000).Iterator()
+	for iterator.Next() {
+		keys = append(keys, iterator.Val())
+	}
+
+	if err := iterator.Err(); err != nil {
+		return nil, err
+	}
+
+	counters := []Counter{}
+	if len(keys) == 0 {
+		return counters, nil
+	}
+
+	vals, err := r.redisClient.MGet(keys...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	for i, key := range keys {
+		val, ok := vals[i].(string)
+		if !ok {
+			// The key expired after it was scanned.
+			continue
+		}
+
+		attempted, err := strconv.ParseInt(val, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		counters = append(counters, Counter{
+			ID:        strings.TrimSuffix(strings.TrimPrefix(key, prefix), suffix),
+			Attempted: attempted,
+		})
+	}
+
+	sort.SliceStable(counters, func(i, j int) bool {
+		if counters[i].Attempted != counters[j].Attempted {
+			return counters[i].Attempted > counters[j].Attempted
+		}
+
+		return counters[i].ID < counters[j].ID
+	})
+
+	if limit > 0 && len(counters) > limit {
+		counters = counters[:limit]
+	}
+
+	return counters, nil
+}
+
+// escapePattern escapes the characters that have a special meaning in the
+// patterns used by the SCAN and KEYS commands.
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch c {
+		case '*', '?', '[', ']', '\\':
+			b.WriteByte('\\')
+		}
+
+		b.WriteRune(c)
+	}
+
+	return b.String()
+}
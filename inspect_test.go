@@ -0,0 +1,46 @@
+package speedbump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounters(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 5 requests/min with a mock clock.
+	mock := clock.NewMock()
+	limiter := NewLimiter(client, PerMinuteHasher{Clock: mock}, 5, WithNamespace("login"))
+	other := NewLimiter(client, PerMinuteHasher{Clock: mock}, 5, WithNamespace("search"))
+
+	// Attempts from the previous period are not included.
+	makeNAttempts(t, limiter, "old_id", 5)
+	mock.Add(time.Minute)
+
+	makeNAttempts(t, limiter, "a", 1)
+	makeNAttempts(t, limiter, "b", 3)
+	makeNAttempts(t, limiter, "c*", 2)
+	makeNAttempts(t, other, "d", 4)
+	require.NoError(t, limiter.Ban("a", time.Hour))
+
+	counters, err := limiter.Counters(0)
+	require.NoError(t, err)
+	assert.Equal(t, []Counter{{"b", 3}, {"c*", 2}, {"a", 1}}, counters)
+
+	counters, err = limiter.Counters(1)
+	require.NoError(t, err)
+	assert.Equal(t, []Counter{{"b", 3}}, counters)
+
+	// The counters of limiters without a namespace can't be told apart from
+	// the ones of other limiters.
+	unnamespaced := NewLimiter(client, PerMinuteHasher{Clock: mock}, 5)
+	makeNAttempts(t, unnamespaced, "e", 1)
+
+	_, err = unnamespaced.Counters(0)
+	assert.Equal(t, ErrNoNamespace, err)
+}
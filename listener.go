@@ -0,0 +1,239 @@
+package speedbump
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Event describes the outcome of an attempt.
+type Event struct {
+	// Name is the name of the limiter.
+	Name string
+	// Policy is the namespace of the limiter.
+	Policy string
+	// ID is the id of the attempt.
+	ID string
+	// Attempted is the value of the counter after the attempt.
+	Attempted int64
+	// Max is the maximum number of attempts per period.
+	Max int64
+	// Remaining is the number of attempts left in the period.
+	Remaining int64
+	// Time is the time at which the attempt started.
+	Time time.Time
+	// Duration is how long the attempt took, including calls to Redis.
+	Duration time.Duration
+	// Err is the error that made the attempt fail, for error and fallback
+	// events.
+	Err error
+	// FailureMode is the failure mode that was applied, for fallback events.
+	FailureMode FailureMode
+	// Shadow is set for limited events of limiters in shadow mode, whose
+	// attempts were allowed anyway.
+	Shadow bool
+}
+
+// Listener receives an event for every attempt made by a limiter. Exactly one
+// of its methods is called per attempt.
+//
+// Listeners are called synchronously, so they should return quickly.
+type Listener interface {
+	// OnAllowed is called when an attempt is allowed.
+	OnAllowed(event Event)
+	// OnLimited is called when an attempt is denied because the limit was
+	// reached. In shadow mode, it is called for attempts that would have been
+	// denied, with Event.Shadow set.
+	OnLimited(event Event)
+	// OnError is called when an attempt fails and the error is returned to the
+	// caller.
+	OnError(event Event)
+	// OnFallback is called when an attempt fails and the result is decided by
+	// the FailOpen or FailClosed mode instead.
+	OnFallback(event Event)
+}
+
+// WithListener adds a listener to the limiter. It can be used multiple times
+// to add multiple listeners.
+func WithListener(listener Listener) Option {
+	return func(r *RateLimiter) {
+		r.listeners = append(r.listeners, listener)
+	}
+}
+
+// notify sends an event to the listeners of the limiter.
+func (r *RateLimiter) notify(event Event, allowed bool) {
+	for _, listener := range r.listeners {
+		switch {
+		case event.Err != nil && event.FailureMode == FailError:
+			listener.OnError(event)
+		case event.Err != nil:
+			listener.OnFallback(event)
+		case allowed:
+			listener.OnAllowed(event)
+		default:
+			listener.OnLimited(event)
+		}
+	}
+}
+
+// SlogListener is a Listener that logs events with a slog.Logger. Allowed
+// attempts are logged at the debug level, limited attempts and fallbacks at
+// the warning level, and errors at the error level. Attempts that would have
+// been limited in shadow mode are logged at the info level.
+type SlogListener struct {
+	logger *slog.Logger
+}
+
+// NewSlogListener creates a listener that logs events with the provided
+// logger. If the logger is nil, slog.Default is used.
+func NewSlogListener(logger *slog.Logger) *SlogListener {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return &SlogListener{logger: logger}
+}
+
+// OnAllowed implements Listener.
+func (l *SlogListener) OnAllowed(event Event) {
+	l.log(slog.LevelDebug, "rate limit attempt allowed", event)
+}
+
+// OnLimited implements Listener.
+func (l *SlogListener) OnLimited(event Event) {
+	if event.Shadow {
+		l.log(slog.LevelInfo, "rate limit exceeded in shadow mode", event)
+		return
+	}
+
+	l.log(slog.LevelWarn, "rate limit exceeded", event)
+}
+
+// OnError implements Listener.
+func (l *SlogListener) OnError(event Event) {
+	l.log(slog.LevelError, "rate limit attempt failed", event)
+}
+
+// OnFallback implements Listener.
+func (l *SlogListener) OnFallback(event Event) {
+	l.log(slog.LevelWarn, "rate limit attempt failed, using fallback", event)
+}
+
+// log writes an event to the logger.
+func (l *SlogListener) log(level slog.Level, msg string, event Event) {
+	attrs := []slog.Attr{
+		slog.String("name", event.Name),
+		slog.String("policy", event.Policy),
+		slog.String("id", event.ID),
+		slog.Int64("attempted", event.Attempted),
+		slog.Int64("max", event.Max),
+		slog.Int64("remaining", event.Remaining),
+		slog.Duration("duration", event.Duration),
+	}
+
+	if event.Err != nil {
+		attrs = append(attrs, slog.String("error", event.Err.Error()))
+	}
+
+	if event.Err != nil && event.FailureMode != FailError {
+		attrs = append(attrs, slog.String("failure_mode", event.FailureMode.String()))
+	}
+
+	if event.Shadow {
+		attrs = append(attrs, slog.Bool("shadow", true))
+	}
+
+	l.logger.LogAttrs(context.Background(), level, msg, attrs...)
+}
+
+// JSONListener is a Listener that writes every event as a line of JSON, which
+// is convenient for feeding events into a log pipeline. Each line looks like:
+//
+//	{"event":"limited","time":"2016-01-01T12:30:00Z","name":"","policy":"login",
+//	 "id":"1.2.3.4","attempted":5,"max":5,"remaining":0,"duration_ms":0.42}
+//
+// (without the line break). Error and fallback events also include "error",
+// fallback events include "failure_mode", and limited events of limiters in
+// shadow mode include "shadow".
+type JSONListener struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+// NewJSONListener creates a listener that writes events to w. Writes are
+// serialized, so the same writer can be shared by multiple limiters through
+// the same listener.
+func NewJSONListener(w io.Writer) *JSONListener {
+	return &JSONListener{encoder: json.NewEncoder(w)}
+}
+
+// jsonEvent is the JSON representation of an event.
+type jsonEvent struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	Name        string    `json:"name"`
+	Policy      string    `json:"policy"`
+	ID          string    `json:"id"`
+	Attempted   int64     `json:"attempted"`
+	Max         int64     `json:"max"`
+	Remaining   int64     `json:"remaining"`
+	DurationMS  float64   `json:"duration_ms"`
+	Error       string    `json:"error,omitempty"`
+	FailureMode string    `json:"failure_mode,omitempty"`
+	Shadow      bool      `json:"shadow,omitempty"`
+}
+
+// OnAllowed implements Listener.
+func (l *JSONListener) OnAllowed(event Event) {
+	l.write("allowed", event)
+}
+
+// OnLimited implements Listener.
+func (l *JSONListener) OnLimited(event Event) {
+	l.write("limited", event)
+}
+
+// OnError implements Listener.
+func (l *JSONListener) OnError(event Event) {
+	l.write("error", event)
+}
+
+// OnFallback implements Listener.
+func (l *JSONListener) OnFallback(event Event) {
+	l.write("fallback", event)
+}
+
+// write encodes an event as a line of JSON.
+func (l *JSONListener) write(kind string, event Event) {
+	line := jsonEvent{
+		Event:      kind,
+		Time:       event.Time,
+		Name:       event.Name,
+		Policy:     event.Policy,
+		ID:         event.ID,
+		Attempted:  event.Attempted,
+		Max:        event.Max,
+		Remaining:  event.Remaining,
+		DurationMS: float64(event.Duration) / float64(time.Millisecond),
+		Shadow:     event.Shadow,
+	}
+
+	if event.Err != nil {
+		line.Error = event.Err.Error()
+	}
+
+	if kind == "fallback" {
+		line.FailureMode = event.FailureMode.String()
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	// There is nowhere to report encoding or write errors to, so they are
+	// ignored, like a logger would.
+	l.encoder.Encode(line)
+}
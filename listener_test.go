@@ -0,0 +1,112 @@
+package speedbump
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingListener is a Listener that keeps track of every event.
+type recordingListener struct {
+	kinds  []string
+	events []Event
+}
+
+func (l *recordingListener) record(kind string, event Event) {
+	l.kinds = append(l.kinds, kind)
+	l.events = append(l.events, event)
+}
+
+func (l *recordingListener) OnAllowed(event Event)  { l.record("allowed", event) }
+func (l *recordingListener) OnLimited(event Event)  { l.record("limited", event) }
+func (l *recordingListener) OnError(event Event)    { l.record("error", event) }
+func (l *recordingListener) OnFallback(event Event) { l.record("fallback", event) }
+
+func TestListener(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 requests/min.
+	listener := &recordingListener{}
+	limiter := NewLimiter(client, PerMinuteHasher{}, 2, WithNamespace("login"), WithListener(listener))
+
+	makeNAttempts(t, limiter, "test_id", 3)
+
+	assert.Equal(t, []string{"allowed", "allowed", "limited"}, listener.kinds)
+	assert.Equal(t, "login", listener.events[0].Policy)
+	assert.Equal(t, "test_id", listener.events[0].ID)
+	assert.Equal(t, int64(1), listener.events[0].Attempted)
+	assert.Equal(t, int64(1), listener.events[0].Remaining)
+	assert.Equal(t, int64(2), listener.events[2].Attempted)
+	assert.Equal(t, int64(0), listener.events[2].Remaining)
+	assert.False(t, listener.events[2].Time.IsZero())
+}
+
+func TestListenerFailures(t *testing.T) {
+	client := createBrokenClient()
+
+	listener := &recordingListener{}
+	limiter := NewLimiter(client, PerMinuteHasher{}, 2, WithListener(listener))
+	_, err := limiter.Attempt("test_id")
+	require.Error(t, err)
+
+	limiter = NewLimiter(client, PerMinuteHasher{}, 2, WithListener(listener), WithFailureMode(FailOpen))
+	_, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"error", "fallback"}, listener.kinds)
+	assert.Error(t, listener.events[1].Err)
+	assert.Equal(t, FailOpen, listener.events[1].FailureMode)
+}
+
+func TestSlogListener(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	listener := NewSlogListener(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	listener.OnAllowed(Event{ID: "allowed_id"})
+	listener.OnLimited(Event{ID: "limited_id", Policy: "login", Attempted: 5, Max: 5})
+	listener.OnFallback(Event{ID: "fallback_id", Err: errors.New("boom"), FailureMode: FailOpen})
+
+	output := buffer.String()
+	// Allowed events are logged at the debug level, which is disabled.
+	assert.NotContains(t, output, "allowed_id")
+	assert.Contains(t, output, "level=WARN msg=\"rate limit exceeded\" name=\"\" policy=login id=limited_id attempted=5 max=5")
+	assert.Contains(t, output, "error=boom failure_mode=open")
+}
+
+func TestJSONListener(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	listener := NewJSONListener(buffer)
+
+	listener.OnLimited(Event{
+		ID:        "1.2.3.4",
+		Policy:    "login",
+		Attempted: 5,
+		Max:       5,
+		Time:      time.Date(2016, 1, 1, 12, 30, 0, 0, time.UTC),
+		Duration:  1500 * time.Microsecond,
+	})
+	listener.OnError(Event{ID: "1.2.3.4", Err: errors.New("boom")})
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	require.Len(t, lines, 2)
+
+	var line map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &line))
+	assert.Equal(t, "limited", line["event"])
+	assert.Equal(t, "2016-01-01T12:30:00Z", line["time"])
+	assert.Equal(t, "login", line["policy"])
+	assert.Equal(t, 1.5, line["duration_ms"])
+	assert.NotContains(t, line, "error")
+
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &line))
+	assert.Equal(t, "error", line["event"])
+	assert.Equal(t, "boom", line["error"])
+}
@@ -0,0 +1,156 @@
+package speedbump
+
+import (
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// FailureMode determines what a RateLimiter does when an attempt fails because
+// of an error, such as the Redis server being unreachable.
+type FailureMode int
+
+const (
+	// FailError returns the error to the caller. This is the default.
+	FailError FailureMode = iota
+	// FailOpen allows the attempt, so that requests are not rejected while the
+	// Redis server is unavailable.
+	FailOpen
+	// FailClosed denies the attempt.
+	FailClosed
+)
+
+// String returns the name of the failure mode.
+func (m FailureMode) String() string {
+	switch m {
+	case FailOpen:
+		return "open"
+	case FailClosed:
+		return "closed"
+	default:
+		return "error"
+	}
+}
+
+// WithFailureMode sets what the limiter does when an attempt fails because of
+// an error. In FailOpen and FailClosed modes, Attempt never returns an error,
+// but errors are still reported to the limiter's Metrics.
+func WithFailureMode(mode FailureMode) Option {
+	return func(r *RateLimiter) {
+		r.failureMode = mode
+	}
+}
+
+// Metrics receives measurements from a RateLimiter. See the prombump package
+// for an implementation that exports them to Prometheus.
+//
+// Every method receives the name and the policy of the limiter, which are the
+// values given to WithName and WithNamespace respectively.
+type Metrics interface {
+	// ObserveDecision is called after every attempt that did not fail.
+	ObserveDecision(name, policy string, allowed bool)
+	// ObserveStoreLatency is called after every call to the Redis server, with
+	// the name of the operation (e.g. "get" or "incr").
+	ObserveStoreLatency(name, policy, operation string, latency time.Duration)
+	// ObserveError is called after every failed call to the Redis server, with
+	// the kind of error returned by ErrorKind.
+	ObserveError(name, policy, kind string)
+	// ObserveFailOpen is called every time an attempt is allowed because of
+	// the FailOpen mode.
+	ObserveFailOpen(name, policy string)
+}
+
+// WithMetrics reports measurements of the limiter to the provided Metrics.
+func WithMetrics(metrics Metrics) Option {
+	return func(r *RateLimiter) {
+		r.metrics = metrics
+	}
+}
+
+// WithName sets the name of the limiter, which is used to tell limiters apart
+// in metrics.
+func WithName(name string) Option {
+	return func(r *RateLimiter) {
+		r.name = name
+	}
+}
+
+// Name returns the name of the limiter.
+func (r *RateLimiter) Name() string {
+	return r.name
+}
+
+// ErrorKind classifies errors returned by the limiter into a few broad kinds,
+// which are useful as metric labels:
+//
+//   - "timeout" for network timeouts.
+//   - "connection" for other network errors, such as refused connections.
+//   - "conflict" for transactions aborted by concurrent changes.
+//   - "parse" for counters that contain unexpected values.
+//   - "redis" for any other error, including errors returned by the server.
+func ErrorKind(err error) string {
+	if netErr, ok := err.(net.Error); ok {
+		if netErr.Timeout() {
+			return "timeout"
+		}
+
+		return "connection"
+	}
+
+	switch err.(type) {
+	case *strconv.NumError:
+		return "parse"
+	}
+
+	switch err {
+	case io.EOF, io.ErrUnexpectedEOF:
+		return "connection"
+	case redis.TxFailedErr:
+		return "conflict"
+	}
+
+	return "redis"
+}
+
+// observeLatency reports the latency of a call to the Redis server that
+// started at the provided time.
+func (r *RateLimiter) observeLatency(operation string, start time.Time) {
+	if r.metrics != nil {
+		r.metrics.ObserveStoreLatency(r.name, r.namespace, operation, time.Since(start))
+	}
+}
+
+// observeError reports a failed call to the Redis server.
+func (r *RateLimiter) observeError(err error) {
+	if r.metrics != nil {
+		r.metrics.ObserveError(r.name, r.namespace, ErrorKind(err))
+	}
+}
+
+// observeDecision reports the outcome of an attempt.
+func (r *RateLimiter) observeDecision(allowed bool) {
+	if r.metrics != nil {
+		r.metrics.ObserveDecision(r.name, r.namespace, allowed)
+	}
+}
+
+// fallback handles an attempt that failed with an error according to the
+// failure mode of the limiter. The error must have been reported with
+// observeError already.
+func (r *RateLimiter) fallback(err error) (bool, error) {
+	switch r.failureMode {
+	case FailOpen:
+		if r.metrics != nil {
+			r.metrics.ObserveFailOpen(r.name, r.namespace)
+		}
+
+		return true, nil
+	case FailClosed:
+		return false, nil
+	default:
+		return false, err
+	}
+}
@@ -0,0 +1,121 @@
+package speedbump
+
+import (
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/redis.v5"
+)
+
+// recordingMetrics is a Metrics implementation that keeps track of every
+// measurement.
+type recordingMetrics struct {
+	sync.Mutex
+	decisions  []bool
+	operations []string
+	errors     []string
+	failOpen   int
+	shadow     int
+}
+
+func (m *recordingMetrics) ObserveDecision(name, policy string, allowed bool) {
+	m.Lock()
+	defer m.Unlock()
+	m.decisions = append(m.decisions, allowed)
+}
+
+func (m *recordingMetrics) ObserveStoreLatency(name, policy, operation string, latency time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	m.operations = append(m.operations, operation)
+}
+
+func (m *recordingMetrics) ObserveError(name, policy, kind string) {
+	m.Lock()
+	defer m.Unlock()
+	m.errors = append(m.errors, kind)
+}
+
+func (m *recordingMetrics) ObserveFailOpen(name, policy string) {
+	m.Lock()
+	defer m.Unlock()
+	m.failOpen++
+}
+
+func (m *recordingMetrics) ObserveShadowLimited(name, policy string) {
+	m.Lock()
+	defer m.Unlock()
+	m.shadow++
+}
+
+// createBrokenClient creates a client for a Redis server that does not exist.
+func createBrokenClient() *redis.Client {
+	return redis.NewClient(&redis.Options{Addr: "localhost:1", MaxRetries: 0})
+}
+
+func TestMetrics(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 1 request/min.
+	metrics := &recordingMetrics{}
+	limiter := NewLimiter(client, PerMinuteHasher{}, 1, WithName("test"), WithMetrics(metrics))
+	assert.Equal(t, "test", limiter.Name())
+
+	makeNAttempts(t, limiter, "test_id", 2)
+
+	assert.Equal(t, []bool{true, false}, metrics.decisions)
+	assert.Equal(t, []string{"get", "incr", "get"}, metrics.operations)
+	assert.Empty(t, metrics.errors)
+}
+
+func TestFailureModes(t *testing.T) {
+	client := createBrokenClient()
+
+	// By default, errors are returned.
+	metrics := &recordingMetrics{}
+	limiter := NewLimiter(client, PerMinuteHasher{}, 1, WithMetrics(metrics))
+	_, err := limiter.Attempt("test_id")
+	assert.Error(t, err)
+	assert.Equal(t, []string{"connection"}, metrics.errors)
+
+	// Fail open allows attempts.
+	metrics = &recordingMetrics{}
+	limiter = NewLimiter(client, PerMinuteHasher{}, 1, WithMetrics(metrics), WithFailureMode(FailOpen))
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 1, metrics.failOpen)
+	assert.Equal(t, []string{"connection"}, metrics.errors)
+	assert.Empty(t, metrics.decisions)
+
+	// Fail closed denies attempts.
+	limiter = NewLimiter(client, PerMinuteHasher{}, 1, WithFailureMode(FailClosed))
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestErrorKind(t *testing.T) {
+	_, parseErr := strconv.ParseInt("nope", 10, 64)
+
+	assert.Equal(t, "timeout", ErrorKind(&net.DNSError{IsTimeout: true}))
+	assert.Equal(t, "connection", ErrorKind(&net.OpError{Op: "dial", Err: errors.New("refused")}))
+	assert.Equal(t, "connection", ErrorKind(io.EOF))
+	assert.Equal(t, "conflict", ErrorKind(redis.TxFailedErr))
+	assert.Equal(t, "parse", ErrorKind(parseErr))
+	assert.Equal(t, "redis", ErrorKind(errors.New("ERR something")))
+}
+
+func TestFailureModeString(t *testing.T) {
+	assert.Equal(t, "error", FailError.String())
+	assert.Equal(t, "open", FailOpen.String())
+	assert.Equal(t, "closed", FailClosed.String())
+}
@@ -1,33 +1,77 @@
 package negronibump
 
 import (
-	"net"
 	"net/http"
 	"time"
 
 	"github.com/codegangsta/negroni"
 	"github.com/dustin/go-humanize"
 	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/httpbump"
 	"github.com/unrolled/render"
 	"gopkg.in/redis.v5"
 )
 
-func RateLimit(client *redis.Client, hasher speedbump.RateHasher, max int64) negroni.HandlerFunc {
-	limiter := speedbump.NewLimiter(client, hasher, max)
+// RateLimit is a Negroni middleware for rate limitting incoming requests based
+// on the client's IP address. Requests matched by any of the skippers are
+// exempt from the limit and are not counted.
+func RateLimit(
+	client *redis.Client,
+	hasher speedbump.RateHasher,
+	max int64,
+	skippers ...httpbump.Skipper,
+) negroni.HandlerFunc {
+	return RateLimitWithLimiter(speedbump.NewLimiter(client, hasher, max), nil, skippers...)
+}
+
+// RateLimitWithLimiter is similar to RateLimit, but it uses an existing
+// limiter, which allows configuring it with any of the options accepted by
+// speedbump.NewLimiter, such as metrics or a failure mode. Any
+// speedbump.Limiter can be used, such as the fake in the speedbumptest package.
+//
+// The key function determines the id of the client, which is used as is. If it
+// is nil, the address of the connecting peer is used, with IPv6 addresses
+// aggregated by httpbump.DefaultIPv6PrefixLength. Use the Key method of a
+// resolver to trust proxies or to aggregate them by another prefix length,
+// such as resolver.WithIPv6Prefix(48).Key.
+func RateLimitWithLimiter(
+	limiter speedbump.Limiter,
+	key func(r *http.Request) string,
+	skippers ...httpbump.Skipper,
+) negroni.HandlerFunc {
 	rnd := render.New()
+	skip := httpbump.Skip(skippers...)
+
+	if key == nil {
+		key = peerKey
+	}
 
 	return func(rw http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
-		ip, _, _ := net.SplitHostPort(r.RemoteAddr)
-		ok, err := limiter.Attempt(ip)
+		if skip(r) {
+			next(rw, r)
+			return
+		}
+
+		decision, err := limiter.Decide(r.Context(), key(r))
 		if err != nil {
 			panic(err)
 		}
 
-		if !ok {
-			nextTime := time.Now().Add(hasher.Duration())
+		speedbump.AddDecisionEvent(r.Context(), limiter, decision.Allowed)
+
+		if !decision.Allowed {
+			// The hasher is read on every rejection, since the period of the
+			// limiter can be updated at runtime.
+			nextTime := time.Now().Add(limiter.Hasher().Duration())
 			rnd.JSON(rw, 429, map[string]string{"error": "Rate limit exceeded. Try again in " + humanize.Time(nextTime)})
 		} else {
 			next(rw, r)
 		}
 	}
 }
+
+// peerKey is the default key of the middleware, which identifies clients by
+// the address of the connecting peer.
+func peerKey(r *http.Request) string {
+	return httpbump.IPKey(httpbump.PeerAddress(r))
+}
@@ -0,0 +1,50 @@
+package negronibump
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/codegangsta/negroni"
+	"github.com/etcinit/speedbump"
+	"github.com/etcinit/speedbump/httpbump"
+	"github.com/etcinit/speedbump/speedbumptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitWithLimiter(t *testing.T) {
+	resolver, err := httpbump.NewResolver()
+	require.NoError(t, err)
+
+	serve := func(handler http.Handler, remoteAddr string) int {
+		recorder := httptest.NewRecorder()
+		request, _ := http.NewRequest("GET", "/", nil)
+		request.RemoteAddr = remoteAddr
+
+		handler.ServeHTTP(recorder, request)
+
+		return recorder.Code
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	// By default, IPv6 clients are grouped by /64.
+	n := negroni.New(RateLimitWithLimiter(speedbumptest.NewLimiter(speedbump.PerMinuteHasher{}, 1), nil))
+	n.UseHandler(ok)
+
+	assert.Equal(t, http.StatusOK, serve(n, "[2001:db8:0:1::1]:30475"))
+	assert.Equal(t, http.StatusOK, serve(n, "[2001:db8:0:2::1]:30475"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(n, "[2001:db8:0:1::2]:30475"))
+
+	// The key of a resolver groups them by its own prefix length.
+	n = negroni.New(RateLimitWithLimiter(
+		speedbumptest.NewLimiter(speedbump.PerMinuteHasher{}, 1),
+		resolver.WithIPv6Prefix(48).Key,
+	))
+	n.UseHandler(ok)
+
+	assert.Equal(t, http.StatusOK, serve(n, "[2001:db8:0:1::1]:30475"))
+	assert.Equal(t, http.StatusTooManyRequests, serve(n, "[2001:db8:0:2::1]:30475"))
+	assert.Equal(t, http.StatusOK, serve(n, "[2001:db9::1]:30475"))
+}
@@ -0,0 +1,67 @@
+package speedbump
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// SetOverride replaces the max of the limiter with a different one for a
+// single id, such as a customer with a higher quota. The override expires after
+// the provided duration, or never if the duration is zero.
+func (r *RateLimiter) SetOverride(id string, max int64, duration time.Duration) error {
+	err := r.redisClient.Set(r.overrideKey(id), max, duration).Err()
+	r.denied.remove(id)
+
+	return err
+}
+
+// RemoveOverride removes the override of an id, if there is one, so that the
+// max of the limiter applies to it again.
+func (r *RateLimiter) RemoveOverride(id string) error {
+	err := r.redisClient.Del(r.overrideKey(id)).Err()
+	r.denied.remove(id)
+
+	return err
+}
+
+// Override returns the max that applies to an id because of an override, and
+// whether there is one.
+func (r *RateLimiter) Override(id string) (int64, bool, error) {
+	val, err := r.redisClient.Get(r.overrideKey(id)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return 0, false, nil
+		}
+
+		return 0, false, err
+	}
+
+	max, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return max, true, nil
+}
+
+// overrideKey generates the key that holds the override of an id.
+func (r *RateLimiter) overrideKey(id string) string {
+	if r.namespace == "" {
+		return "override:" + id
+	}
+
+	return r.namespace + ":override:" + id
+}
+
+// limitFor returns the max that applies to an id given the value of its
+// override key, as returned by MGET, and the max of the limiter.
+func limitFor(override interface{}, max int64) (int64, error) {
+	val, exists := override.(string)
+	if !exists {
+		return max, nil
+	}
+
+	return strconv.ParseInt(val, 10, 64)
+}
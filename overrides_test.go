@@ -0,0 +1,55 @@
+package speedbump
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverride(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 requests/min.
+	limiter := NewLimiter(client, PerMinuteHasher{}, 2, WithNamespace("api"))
+
+	max, ok, err := limiter.Override("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+	assert.Zero(t, max)
+
+	require.NoError(t, limiter.SetOverride("test_id", 4, time.Hour))
+
+	max, ok, err = limiter.Override("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(4), max)
+
+	left, err := limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), left)
+
+	makeNAttempts(t, limiter, "test_id", 4)
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Other ids still use the max of the limiter.
+	makeNAttempts(t, limiter, "other_id", 2)
+	ok, err = limiter.Attempt("other_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Once the override is removed, the id is over the max of the limiter.
+	require.NoError(t, limiter.RemoveOverride("test_id"))
+
+	left, err = limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), left)
+
+	attempted, err := limiter.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), attempted)
+}
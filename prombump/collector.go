@@ -0,0 +1,130 @@
+// Package prombump exports Speedbump metrics to Prometheus.
+package prombump
+
+import (
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector that receives measurements from one or
+// more limiters. It exposes the following metrics:
+//
+//   - speedbump_decisions_total: attempts by limiter name, policy and
+//     decision ("allowed" or "denied").
+//   - speedbump_store_duration_seconds: latency of calls to the Redis server
+//     by limiter name, policy and operation.
+//   - speedbump_errors_total: failed calls to the Redis server by limiter
+//     name, policy and kind of error (see speedbump.ErrorKind).
+//   - speedbump_fail_open_total: attempts allowed because the Redis server
+//     failed and the limiter is in fail-open mode.
+//   - speedbump_shadow_limited_total: attempts over the limit that were
+//     allowed because the limiter is in shadow mode.
+//
+// The collector is passed to limiters with speedbump.WithMetrics:
+//
+//	collector := prombump.NewCollector()
+//	prometheus.MustRegister(collector)
+//
+//	limiter := speedbump.NewLimiter(
+//	  client, speedbump.PerMinuteHasher{}, 100,
+//	  speedbump.WithName("api"),
+//	  speedbump.WithMetrics(collector),
+//	)
+type Collector struct {
+	decisions *prometheus.CounterVec
+	latency   *prometheus.HistogramVec
+	errors    *prometheus.CounterVec
+	failOpen  *prometheus.CounterVec
+	shadow    *prometheus.CounterVec
+}
+
+// Collector implements speedbump.Metrics and speedbump.ShadowMetrics.
+var (
+	_ speedbump.Metrics       = (*Collector)(nil)
+	_ speedbump.ShadowMetrics = (*Collector)(nil)
+)
+
+// NewCollector creates a new collector. It has to be registered with a
+// Prometheus registry for its metrics to be exported.
+func NewCollector() *Collector {
+	labels := []string{"name", "policy"}
+
+	return &Collector{
+		decisions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "speedbump",
+			Name:      "decisions_total",
+			Help:      "Number of rate limit decisions.",
+		}, append(labels, "decision")),
+		latency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "speedbump",
+			Name:      "store_duration_seconds",
+			Help:      "Latency of calls to the rate limit store.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, append(labels, "operation")),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "speedbump",
+			Name:      "errors_total",
+			Help:      "Number of failed calls to the rate limit store.",
+		}, append(labels, "kind")),
+		failOpen: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "speedbump",
+			Name:      "fail_open_total",
+			Help:      "Number of attempts allowed because the rate limit store failed.",
+		}, labels),
+		shadow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "speedbump",
+			Name:      "shadow_limited_total",
+			Help:      "Number of attempts over the limit allowed because of shadow mode.",
+		}, labels),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.decisions.Describe(ch)
+	c.latency.Describe(ch)
+	c.errors.Describe(ch)
+	c.failOpen.Describe(ch)
+	c.shadow.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.decisions.Collect(ch)
+	c.latency.Collect(ch)
+	c.errors.Collect(ch)
+	c.failOpen.Collect(ch)
+	c.shadow.Collect(ch)
+}
+
+// ObserveDecision implements speedbump.Metrics.
+func (c *Collector) ObserveDecision(name, policy string, allowed bool) {
+	decision := "denied"
+	if allowed {
+		decision = "allowed"
+	}
+
+	c.decisions.WithLabelValues(name, policy, decision).Inc()
+}
+
+// ObserveStoreLatency implements speedbump.Metrics.
+func (c *Collector) ObserveStoreLatency(name, policy, operation string, latency time.Duration) {
+	c.latency.WithLabelValues(name, policy, operation).Observe(latency.Seconds())
+}
+
+// ObserveError implements speedbump.Metrics.
+func (c *Collector) ObserveError(name, policy, kind string) {
+	c.errors.WithLabelValues(name, policy, kind).Inc()
+}
+
+// ObserveFailOpen implements speedbump.Metrics.
+func (c *Collector) ObserveFailOpen(name, policy string) {
+	c.failOpen.WithLabelValues(name, policy).Inc()
+}
+
+// ObserveShadowLimited implements speedbump.ShadowMetrics.
+func (c *Collector) ObserveShadowLimited(name, policy string) {
+	c.shadow.WithLabelValues(name, policy).Inc()
+}
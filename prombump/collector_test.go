@@ -0,0 +1,52 @@
+package prombump
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollector(t *testing.T) {
+	collector := NewCollector()
+	registry := prometheus.NewRegistry()
+	require.NoError(t, registry.Register(collector))
+
+	collector.ObserveDecision("api", "login", true)
+	collector.ObserveDecision("api", "login", true)
+	collector.ObserveDecision("api", "login", false)
+	collector.ObserveStoreLatency("api", "login", "get", time.Millisecond)
+	collector.ObserveError("api", "login", "timeout")
+	collector.ObserveFailOpen("api", "login")
+	collector.ObserveShadowLimited("api", "login")
+
+	expected := `
+# HELP speedbump_decisions_total Number of rate limit decisions.
+# TYPE speedbump_decisions_total counter
+speedbump_decisions_total{decision="allowed",name="api",policy="login"} 2
+speedbump_decisions_total{decision="denied",name="api",policy="login"} 1
+# HELP speedbump_errors_total Number of failed calls to the rate limit store.
+# TYPE speedbump_errors_total counter
+speedbump_errors_total{kind="timeout",name="api",policy="login"} 1
+# HELP speedbump_fail_open_total Number of attempts allowed because the rate limit store failed.
+# TYPE speedbump_fail_open_total counter
+speedbump_fail_open_total{name="api",policy="login"} 1
+# HELP speedbump_shadow_limited_total Number of attempts over the limit allowed because of shadow mode.
+# TYPE speedbump_shadow_limited_total counter
+speedbump_shadow_limited_total{name="api",policy="login"} 1
+`
+	assert.NoError(t, testutil.GatherAndCompare(
+		registry,
+		strings.NewReader(expected),
+		"speedbump_decisions_total",
+		"speedbump_errors_total",
+		"speedbump_fail_open_total",
+		"speedbump_shadow_limited_total",
+	))
+
+	assert.Equal(t, 1, testutil.CollectAndCount(collector, "speedbump_store_duration_seconds"))
+}
@@ -0,0 +1,96 @@
+package speedbump
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// refundScript gives back attempts to a counter, without going below zero.
+// KEYS has the counter and ARGV has the number of attempts. Counters that
+// already expired are not created again.
+var refundScript = redis.NewScript(`
+local attempted = tonumber(redis.call("GET", KEYS[1]) or 0)
+local refund = math.min(attempted, tonumber(ARGV[1]))
+
+if refund > 0 then
+  redis.call("DECRBY", KEYS[1], refund)
+end
+
+return refund
+`)
+
+// receipt records where an attempt was counted, so that it can be refunded
+// even after the period is over.
+type receipt struct {
+	id   string
+	key  string
+	cost int64
+	// at is when the attempt was recorded in the usage history, if it was.
+	at       time.Time
+	refunded atomic.Bool
+}
+
+// Refundable returns whether the attempt was counted and can be refunded.
+func (d Decision) Refundable() bool {
+	return d.receipt != nil && !d.receipt.refunded.Load()
+}
+
+// Refund gives back the attempts counted by a decision, such as when the
+// request failed because of the server or was never performed. The counter of
+// the period in which the attempt was made is decremented, even if that period
+// is over, and counters never go below zero.
+//
+// Decisions that were not counted, such as denied attempts or attempts decided
+// by the failure mode, are not refunded. A decision is only refunded once,
+// even if Refund is called multiple times.
+func (r *RateLimiter) Refund(ctx context.Context, decision Decision) error {
+	return r.refund(ctx, decision.receipt)
+}
+
+// refund gives back the attempts recorded by a receipt, once.
+func (r *RateLimiter) refund(ctx context.Context, receipt *receipt) error {
+	if receipt == nil || !receipt.refunded.CompareAndSwap(false, true) {
+		return nil
+	}
+
+	_, span := r.startSpan(ctx, "Refund", receipt.id)
+
+	start := time.Now()
+	err := refundScript.Run(r.redisClient, []string{receipt.key}, receipt.cost).Err()
+	r.observeLatency("refund", start)
+
+	if err != nil {
+		// The decision can be refunded again if the call failed.
+		receipt.refunded.Store(false)
+		r.observeError(err)
+	} else if err = r.refundUsage(receipt.id, receipt.cost, receipt.at); err != nil {
+		r.observeError(err)
+	}
+
+	// The id may have been cached as denied before the refund.
+	r.denied.remove(receipt.id)
+
+	endSpan(span, err)
+
+	return err
+}
+
+// Refund gives back the attempts counted by a decision on every level of the
+// hierarchy. See RateLimiter.Refund.
+func (h *Hierarchy) Refund(ctx context.Context, decision HierarchyDecision) error {
+	var firstErr error
+	for i, level := range h.levels {
+		if i >= len(decision.Levels) {
+			break
+		}
+
+		if err := level.Limiter.Refund(ctx, decision.Levels[i]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
@@ -0,0 +1,151 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutReceipts removes the receipts of decisions, so that they can be
+// compared to the expected ones.
+func withoutReceipts(decisions ...Decision) []Decision {
+	stripped := make([]Decision, len(decisions))
+	for i, decision := range decisions {
+		decision.receipt = nil
+		stripped[i] = decision
+	}
+
+	return stripped
+}
+
+// refundable returns whether each decision can be refunded.
+func refundable(decisions []Decision) []bool {
+	values := make([]bool, len(decisions))
+	for i, decision := range decisions {
+		values[i] = decision.Refundable()
+	}
+
+	return values
+}
+
+func TestRefund(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 requests/min with a mock clock.
+	mock := clock.NewMock()
+	hasher := PerMinuteHasher{Clock: mock}
+	limiter := NewLimiter(client, hasher, 2, WithDeniedCache(10))
+
+	ctx := context.Background()
+
+	first, err := limiter.Decide(ctx, "test_id")
+	require.NoError(t, err)
+	assert.True(t, first.Refundable())
+
+	_, err = limiter.Decide(ctx, "test_id")
+	require.NoError(t, err)
+
+	denied, err := limiter.Decide(ctx, "test_id")
+	require.NoError(t, err)
+	assert.False(t, denied.Allowed)
+	assert.False(t, denied.Refundable())
+	require.NoError(t, limiter.Refund(ctx, denied))
+
+	// Refunds make room again, even for ids in the denied cache.
+	require.NoError(t, limiter.Refund(ctx, first))
+	assert.False(t, first.Refundable())
+
+	left, err := limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	// A decision is only refunded once.
+	require.NoError(t, limiter.Refund(ctx, first))
+
+	left, err = limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	last, err := limiter.Decide(ctx, "test_id")
+	require.NoError(t, err)
+	assert.True(t, last.Allowed)
+
+	// Refunds apply to the period of the attempt, even after it is over.
+	key := hasher.Hash("test_id")
+	mock.Add(time.Minute)
+
+	makeNAttempts(t, limiter, "test_id", 1)
+	require.NoError(t, limiter.Refund(ctx, last))
+
+	attempted, err := client.Get(key).Int64()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), attempted)
+
+	attempted, err = limiter.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), attempted)
+}
+
+func TestRefundNeverBelowZero(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	limiter := NewLimiter(client, PerHourHasher{}, 5)
+
+	ctx := context.Background()
+	decisions, err := limiter.AttemptMany(ctx, []string{"test_id"}, []int64{3})
+	require.NoError(t, err)
+
+	// The cost of the attempt is refunded, but only down to zero.
+	_, err = client.DecrBy(limiter.hash("test_id"), 2).Result()
+	require.NoError(t, err)
+	require.NoError(t, limiter.Refund(ctx, decisions[0]))
+
+	attempted, err := limiter.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Zero(t, attempted)
+
+	// Counters that were reset are not created again.
+	decision, err := limiter.Decide(ctx, "other_id")
+	require.NoError(t, err)
+	require.NoError(t, limiter.Reset("other_id"))
+	require.NoError(t, limiter.Refund(ctx, decision))
+
+	exists, err := client.Exists(limiter.hash("other_id")).Result()
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestRefundHierarchy(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	users := NewLimiter(client, PerMinuteHasher{}, 1, WithNamespace("user"))
+	tenants := NewLimiter(client, PerMinuteHasher{}, 1, WithNamespace("tenant"))
+	global := NewLimiter(client, PerMinuteHasher{}, 1, WithNamespace("global"))
+	hierarchy := createHierarchy(t, users, tenants, global)
+
+	ctx := context.Background()
+
+	decision, err := hierarchy.Attempt(ctx, "alice", "acme", "")
+	require.NoError(t, err)
+	assert.Equal(t, []bool{true, true, true}, refundable(decision.Levels))
+
+	require.NoError(t, hierarchy.Refund(ctx, decision))
+
+	decision, err = hierarchy.Attempt(ctx, "bob", "acme", "")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+
+	// Rejected attempts were not counted on any level.
+	decision, err = hierarchy.Attempt(ctx, "carol", "acme", "")
+	require.NoError(t, err)
+	assert.False(t, decision.Allowed)
+	assert.Equal(t, []bool{false, false, false}, refundable(decision.Levels))
+}
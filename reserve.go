@@ -0,0 +1,199 @@
+package speedbump
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// reserveWindows is how many periods ahead of the current one Reserve looks
+// for room.
+const reserveWindows = 60
+
+// ErrNotReservable is returned by Reserve when the hasher of the limiter
+// doesn't implement TimeHasher.
+var ErrNotReservable = errors.New("speedbump: hasher doesn't support reservations")
+
+// reserveScript reserves attempts in the first period with enough room for
+// them. KEYS has the ban and override keys, followed by the counters of the
+// current period and the ones after it. ARGV has the max and the number of
+// attempts, followed by the expiration of every counter in milliseconds.
+//
+// It returns the position of the period the attempts were reserved in, where 0
+// is the current one, or -1 if they could not be reserved.
+var reserveScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 1 then
+  return -1
+end
+
+local limit = tonumber(redis.call("GET", KEYS[2]) or ARGV[1])
+local n = tonumber(ARGV[2])
+
+for i = 3, #KEYS do
+  local attempted = tonumber(redis.call("GET", KEYS[i]) or 0)
+
+  if attempted + n <= limit then
+    redis.call("INCRBY", KEYS[i], n)
+
+    local ttl = tonumber(ARGV[i])
+    if redis.call("PTTL", KEYS[i]) < ttl then
+      redis.call("PEXPIRE", KEYS[i], ttl)
+    end
+
+    return i - 3
+  end
+end
+
+return -1
+`)
+
+// Reservation holds attempts reserved by Reserve, which can be performed once
+// its delay is over.
+type Reservation struct {
+	ok      bool
+	at      time.Time
+	hasher  TimeHasher
+	limiter *RateLimiter
+	receipt *receipt
+}
+
+// OK returns whether the attempts were reserved. Attempts are not reserved if
+// they are more than the limit, the id is banned, or no period within the
+// reservation horizon has room for them.
+func (r *Reservation) OK() bool {
+	return r.ok
+}
+
+// Time returns when the reserved attempts can be performed, which is the
+// start of the period they were reserved in, or the time of the reservation if
+// it was the current one.
+func (r *Reservation) Time() time.Time {
+	return r.at
+}
+
+// Delay returns how long to wait before performing the reserved attempts. It
+// is zero once the period they were reserved in started.
+func (r *Reservation) Delay() time.Duration {
+	if !r.ok {
+		return 0
+	}
+
+	if delay := r.at.Sub(r.hasher.Now()); delay > 0 {
+		return delay
+	}
+
+	return 0
+}
+
+// Cancel gives back the reserved attempts, such as when the caller decides not
+// to wait for them. Like Cancel in golang.org/x/time/rate, it only has an
+// effect before the time of the reservation: once it is reached, the attempts
+// may already have been performed, so they are kept. Like a refund, it only has an
+// effect once, and counters never go below zero.
+func (r *Reservation) Cancel() error {
+	if !r.ok || !r.hasher.Now().Before(r.at) {
+		return nil
+	}
+
+	return r.limiter.refund(context.Background(), r.receipt)
+}
+
+// Reserve reserves n attempts for an id, like Reserve in golang.org/x/time/rate.
+// The attempts are counted in the current period if it has room for them, or
+// in the first period after it that does, up to 60 periods ahead. Callers
+// should wait for the delay of the reservation before performing them, or
+// cancel it.
+//
+//	reservation, err := limiter.Reserve(ctx, id, 1)
+//	if err != nil || !reservation.OK() {
+//	  return err
+//	}
+//
+//	time.Sleep(reservation.Delay())
+//
+// Reservations use the same counters as Attempt, so attempts made later in a
+// period find less room. They can only be cancelled before their time, so
+// reservations in the current period can't be cancelled. Overrides and bans are respected, but the denied
+// cache, shadow mode and escalation are not. The hasher of the limiter has to
+// implement TimeHasher, which all the built-in hashers do.
+func (r *RateLimiter) Reserve(ctx context.Context, id string, n int64) (*Reservation, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("speedbump: can't reserve %d attempts", n)
+	}
+
+	if r.serverTime {
+		return nil, ErrServerTime
+	}
+
+	current := r.current()
+	hasher, ok := current.hasher.(TimeHasher)
+	if !ok {
+		return nil, ErrNotReservable
+	}
+
+	_, span := r.startSpan(ctx, "Reserve", id)
+
+	now := hasher.Now()
+	end := hasher.PeriodEnd()
+	duration := hasher.Duration()
+
+	keys := []string{r.banKey(id), r.overrideKey(id)}
+	args := []interface{}{current.max, n}
+	starts := []time.Time{now}
+
+	for i := 0; i <= reserveWindows; i++ {
+		if i > 0 {
+			starts = append(starts, end.Add(time.Duration(i-1)*duration))
+		}
+
+		ttl := end.Add(time.Duration(i) * duration).Sub(now)
+		if ttl < duration {
+			ttl = duration
+		}
+
+		keys = append(keys, r.hashAt(hasher, id, starts[i]))
+		args = append(args, milliseconds(ttl))
+	}
+
+	start := time.Now()
+	reply, err := reserveScript.Run(r.redisClient, keys, args...).Result()
+	r.observeLatency("reserve", start)
+
+	reservation := &Reservation{hasher: hasher, limiter: r}
+
+	if err == nil {
+		position, valid := reply.(int64)
+		if !valid {
+			err = fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+		} else if position >= 0 {
+			reservation.ok = true
+			reservation.at = starts[position]
+			reservation.receipt = &receipt{id: id, key: keys[2+position], cost: n}
+			reservation.receipt.at = r.recordUsage(usageEntry{id: id, cost: n})
+		}
+	}
+
+	if err != nil {
+		r.observeError(err)
+		endSpan(span, err)
+
+		return nil, err
+	}
+
+	endSpan(span, nil)
+
+	return reservation, nil
+}
+
+// hashAt generates the key of the counter for an id during the period that
+// contains t.
+func (r *RateLimiter) hashAt(hasher TimeHasher, id string, t time.Time) string {
+	if r.namespace == "" {
+		return hasher.HashAt(id, t)
+	}
+
+	return r.namespace + ":" + hasher.HashAt(id, t)
+}
@@ -0,0 +1,129 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// constantHasher is a hasher that doesn't support reservations.
+type constantHasher struct{}
+
+func (constantHasher) Hash(id string) string {
+	return id
+}
+
+func (constantHasher) Duration() time.Duration {
+	return time.Minute
+}
+
+func TestReserve(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 3 requests/min with a mock clock.
+	mock := clock.NewMock()
+	mock.Add(10 * time.Second)
+	hasher := PerMinuteHasher{Clock: mock}
+	limiter := NewLimiter(client, hasher, 3)
+
+	ctx := context.Background()
+
+	// The current period has room for the first reservation.
+	first, err := limiter.Reserve(ctx, "test_id", 2)
+	require.NoError(t, err)
+	assert.True(t, first.OK())
+	assert.Equal(t, time.Duration(0), first.Delay())
+	assert.Equal(t, mock.Now(), first.Time())
+
+	left, err := limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	// Reservations in the current period can't be cancelled.
+	require.NoError(t, first.Cancel())
+
+	left, err = limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	// The second one has to wait for the next period.
+	second, err := limiter.Reserve(ctx, "test_id", 2)
+	require.NoError(t, err)
+	assert.True(t, second.OK())
+	assert.Equal(t, 50*time.Second, second.Delay())
+	assert.Equal(t, hasher.PeriodEnd(), second.Time())
+
+	third, err := limiter.Reserve(ctx, "test_id", 2)
+	require.NoError(t, err)
+	assert.Equal(t, 110*time.Second, third.Delay())
+
+	// Cancelling a reservation before its period starts gives the attempts
+	// back, but only once.
+	require.NoError(t, third.Cancel())
+	require.NoError(t, third.Cancel())
+
+	// Once the period starts, the reserved attempts are counted.
+	mock.Add(50 * time.Second)
+	assert.Equal(t, time.Duration(0), second.Delay())
+
+	left, err = limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	// After that, they may have been performed, so they can't be cancelled.
+	require.NoError(t, second.Cancel())
+
+	left, err = limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	// The period of the cancelled reservation has room for every attempt.
+	mock.Add(time.Minute)
+
+	left, err = limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), left)
+}
+
+func TestReserveRejected(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 3 requests/min with a mock clock.
+	mock := clock.NewMock()
+	limiter := NewLimiter(client, PerMinuteHasher{Clock: mock}, 3)
+
+	ctx := context.Background()
+
+	// More attempts than the limit can never be reserved.
+	reservation, err := limiter.Reserve(ctx, "test_id", 4)
+	require.NoError(t, err)
+	assert.False(t, reservation.OK())
+	assert.Equal(t, time.Duration(0), reservation.Delay())
+	require.NoError(t, reservation.Cancel())
+
+	// Overrides change the limit of reservations.
+	require.NoError(t, limiter.SetOverride("test_id", 4, time.Hour))
+
+	reservation, err = limiter.Reserve(ctx, "test_id", 4)
+	require.NoError(t, err)
+	assert.True(t, reservation.OK())
+
+	// Banned ids can't reserve attempts.
+	require.NoError(t, limiter.Ban("test_id", time.Hour))
+
+	reservation, err = limiter.Reserve(ctx, "test_id", 1)
+	require.NoError(t, err)
+	assert.False(t, reservation.OK())
+
+	_, err = limiter.Reserve(ctx, "test_id", 0)
+	assert.Error(t, err)
+
+	_, err = NewLimiter(client, constantHasher{}, 3).Reserve(ctx, "test_id", 1)
+	assert.Equal(t, ErrNotReservable, err)
+}
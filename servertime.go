@@ -0,0 +1,212 @@
+package speedbump
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"gopkg.in/redis.v5"
+)
+
+// ErrServerTime is returned by operations that don't support limiters created
+// with WithServerTime.
+var ErrServerTime = errors.New("speedbump: operation not supported with server time")
+
+// serverTimeScript reads, and optionally counts, the counter of an id in the
+// current window according to the clock of the Redis server. KEYS has the
+// prefix of the counters, the ban key and the override key. ARGV has the max,
+// the duration of a window in milliseconds and the mode, which is "get" to
+// only read the counter, "attempt" to count it if there is room, "count" to
+// always count it, or "reset" to delete it.
+//
+// It returns whether the id is banned, the counter, the limit, the milliseconds
+// left in the window, whether the counter was incremented and its key.
+var serverTimeScript = redis.NewScript(`
+-- Writes after reading the time are only allowed when commands are
+-- replicated instead of the script.
+redis.replicate_commands()
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local period = tonumber(ARGV[2])
+local window = math.floor(now / period)
+local counter = KEYS[1] .. ":t" .. string.format("%d", window)
+
+local banned = redis.call("EXISTS", KEYS[2])
+local limit = tonumber(redis.call("GET", KEYS[3]) or ARGV[1])
+local attempted = tonumber(redis.call("GET", counter) or 0)
+local left = (window + 1) * period - now
+local counted = 0
+
+if ARGV[3] == "reset" then
+  redis.call("DEL", counter)
+  attempted = 0
+end
+
+if ARGV[3] == "count" or (ARGV[3] == "attempt" and banned == 0 and attempted < limit) then
+  attempted = redis.call("INCR", counter)
+  redis.call("PEXPIRE", counter, period)
+  counted = 1
+end
+
+return {banned, attempted, limit, left, counted, counter}
+`)
+
+// WithServerTime makes the limiter pick the current period using the clock of
+// the Redis server instead of the clock of the hasher, so that every instance
+// of an application agrees on the current period even if their clocks are
+// skewed.
+//
+// Periods are windows of the duration of the hasher aligned to the Unix epoch,
+// like the ones of WindowHasher, and counters are stored under their own keys,
+// so the hasher only determines the duration. Since the keys of the counters
+// are generated inside a script, on a Redis Cluster the namespace or the ids
+// should have a hash tag, such as "{api}".
+//
+// Attempt, Decide, Check, Count, Reset, Counters, RetryAfter and the methods
+// that read counters support it, while AttemptMany, Reserve and hierarchies
+// return ErrServerTime.
+func WithServerTime() Option {
+	return func(r *RateLimiter) {
+		r.serverTime = true
+	}
+}
+
+// serverTimeResult is the reply of serverTimeScript.
+type serverTimeResult struct {
+	banned    bool
+	attempted int64
+	max       int64
+	left      time.Duration
+	counted   bool
+	key       string
+}
+
+// runServerTime runs serverTimeScript for an id in the provided mode.
+func (r *RateLimiter) runServerTime(current limits, id, mode string) (serverTimeResult, error) {
+	keys := []string{r.serverTimeKey(id), r.banKey(id), r.overrideKey(id)}
+	args := []interface{}{current.max, milliseconds(current.hasher.Duration()), mode}
+
+	start := time.Now()
+	reply, err := serverTimeScript.Run(r.redisClient, keys, args...).Result()
+	r.observeLatency("script", start)
+
+	if err != nil {
+		return serverTimeResult{}, err
+	}
+
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 6 {
+		return serverTimeResult{}, fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+	}
+
+	numbers := make([]int64, 5)
+	for i := range numbers {
+		if numbers[i], ok = values[i].(int64); !ok {
+			return serverTimeResult{}, fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+		}
+	}
+
+	key, ok := values[5].(string)
+	if !ok {
+		return serverTimeResult{}, fmt.Errorf("speedbump: unexpected reply from script: %v", reply)
+	}
+
+	return serverTimeResult{
+		banned:    numbers[0] == 1,
+		attempted: numbers[1],
+		max:       numbers[2],
+		left:      time.Duration(numbers[3]) * time.Millisecond,
+		counted:   numbers[4] == 1,
+		key:       key,
+	}, nil
+}
+
+// attemptServerTime is like attempt, but for limiters that use the time of
+// the Redis server.
+func (r *RateLimiter) attemptServerTime(current limits, id string, count bool) (attemptResult, error) {
+	// Ids that are known to be over the limit until the end of the window are
+	// denied without calling the Redis server.
+	if r.denied != nil {
+		if entry, ok := r.denied.get(id, time.Now()); ok {
+			return attemptResult{attempted: entry.attempted, max: entry.max, cached: true}, nil
+		}
+	}
+
+	mode := "attempt"
+	if !count {
+		mode = "get"
+	}
+
+	result, err := r.runServerTime(current, id, mode)
+	if err != nil {
+		return attemptResult{}, err
+	}
+
+	// Banned ids are denied without being counted.
+	if result.banned {
+		return attemptResult{attempted: result.max, max: result.max, banned: true}, nil
+	}
+
+	if result.attempted >= result.max && !result.counted {
+		// Going over the limit is an offence when the limiter escalates.
+		if err := r.escalate(id); err != nil {
+			return attemptResult{}, err
+		}
+
+		if r.denied != nil {
+			// The window ends after the time left according to the server,
+			// wherever the local clock is.
+			now := time.Now()
+			r.denied.add(id, deniedEntry{
+				until:     now.Add(result.left),
+				attempted: result.attempted,
+				max:       result.max,
+			}, now)
+		}
+
+		return attemptResult{attempted: result.attempted, max: result.max}, nil
+	}
+
+	if !result.counted {
+		return attemptResult{attempted: result.attempted, max: result.max, ok: true}, nil
+	}
+
+	return attemptResult{
+		attempted: result.attempted,
+		max:       result.max,
+		ok:        true,
+		key:       result.key,
+		cost:      1,
+	}, nil
+}
+
+// serverWindow returns the suffix of the counters of the current window
+// according to the clock of the Redis server, and how long is left in it.
+func (r *RateLimiter) serverWindow(hasher RateHasher) (string, time.Duration, error) {
+	start := time.Now()
+	now, err := r.redisClient.Time().Result()
+	r.observeLatency("time", start)
+
+	if err != nil {
+		return "", 0, err
+	}
+
+	period := milliseconds(hasher.Duration())
+	ms := now.UnixNano() / int64(time.Millisecond)
+	window := ms / period
+	left := time.Duration((window+1)*period-ms) * time.Millisecond
+
+	return ":t" + strconv.FormatInt(window, 10), left, nil
+}
+
+// serverTimeKey generates the prefix of the counters of an id when the limiter
+// uses the time of the Redis server.
+func (r *RateLimiter) serverTimeKey(id string) string {
+	if r.namespace == "" {
+		return id
+	}
+
+	return r.namespace + ":" + id
+}
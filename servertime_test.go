@@ -0,0 +1,146 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServerTime(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create two limiters of 3 requests/day whose clocks are a minute apart,
+	// as if they ran on different servers. Long periods keep the test away
+	// from the end of a window.
+	skewed := clock.NewMock()
+	skewed.Add(time.Minute)
+
+	first := NewLimiter(client, WindowHasher{Clock: clock.NewMock(), Window: 24 * time.Hour}, 3, WithServerTime())
+	second := NewLimiter(client, WindowHasher{Clock: skewed, Window: 24 * time.Hour}, 3, WithServerTime())
+
+	ctx := context.Background()
+
+	has, err := first.Has("test_id")
+	require.NoError(t, err)
+	assert.False(t, has)
+
+	decision, err := first.Decide(ctx, "test_id")
+	require.NoError(t, err)
+	assert.True(t, decision.Allowed)
+	assert.True(t, decision.Refundable())
+
+	// Both limiters use the same window, whatever their clocks say.
+	ok, err := second.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	attempted, err := first.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), attempted)
+
+	has, err = second.Has("test_id")
+	require.NoError(t, err)
+	assert.True(t, has)
+
+	// Checks don't count attempts, but Count does.
+	checked, err := second.Check(ctx, "test_id")
+	require.NoError(t, err)
+	assert.True(t, checked.Allowed)
+	assert.False(t, checked.Refundable())
+
+	require.NoError(t, second.Count(ctx, "test_id"))
+
+	denied, err := first.Decide(ctx, "test_id")
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Limited: true, Attempted: 3, Max: 3}, denied)
+
+	// Refunds use the counter of the window the attempt was counted in.
+	require.NoError(t, first.Refund(ctx, decision))
+
+	left, err := second.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), left)
+
+	// Overrides and bans are respected.
+	require.NoError(t, first.SetOverride("test_id", 10, time.Hour))
+
+	left, err = first.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), left)
+
+	require.NoError(t, first.Ban("test_id", time.Hour))
+
+	ok, err = second.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestServerTimeUnsupported(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	limiter := NewLimiter(client, PerMinuteHasher{}, 3, WithServerTime())
+	ctx := context.Background()
+
+	_, err := limiter.AttemptMany(ctx, []string{"test_id"}, nil)
+	assert.Equal(t, ErrServerTime, err)
+
+	_, err = limiter.Reserve(ctx, "test_id", 1)
+	assert.Equal(t, ErrServerTime, err)
+
+	_, err = NewHierarchy(Level{Name: "user", Limiter: limiter})
+	assert.Equal(t, ErrServerTime, err)
+}
+
+func TestServerTimeAdmin(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 2 requests/hour whose clock is far from the one of the
+	// Redis server.
+	hasher := WindowHasher{Clock: clock.NewMock(), Window: time.Hour}
+	limiter := NewLimiter(client, hasher, 2, WithServerTime(), WithNamespace("api"))
+
+	for i := 0; i < 3; i++ {
+		_, err := limiter.Attempt("test_id")
+		require.NoError(t, err)
+	}
+
+	_, err := limiter.Attempt("other_id")
+	require.NoError(t, err)
+
+	attempted, err := limiter.Attempted("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), attempted)
+
+	left, err := limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), left)
+
+	counters, err := limiter.Counters(0)
+	require.NoError(t, err)
+	assert.Equal(t, []Counter{{ID: "test_id", Attempted: 2}, {ID: "other_id", Attempted: 1}}, counters)
+
+	// The period ends according to the clock of the Redis server, not the one
+	// of the hasher, which is still at the Unix epoch.
+	now := time.Now()
+	expected := now.Truncate(time.Hour).Add(time.Hour).Sub(now)
+	assert.InDelta(t, float64(expected), float64(limiter.RetryAfter()), float64(time.Second))
+
+	// Resetting clears the counter of the current window.
+	require.NoError(t, limiter.Reset("test_id"))
+
+	left, err = limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), left)
+
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,35 @@
+package speedbump
+
+// WithShadowMode puts the limiter in shadow mode, which is useful to find out
+// who a new limit would affect before enforcing it. Attempts are counted and
+// evaluated as usual, but attempts over the limit are allowed anyway.
+//
+// Would-be rejections are still reported: listeners receive them through
+// OnLimited with Event.Shadow set, metrics that implement ShadowMetrics count
+// them, and spans record them with AttributeShadowLimited. Decide tells them
+// apart from regular decisions.
+func WithShadowMode() Option {
+	return func(r *RateLimiter) {
+		r.shadow = true
+	}
+}
+
+// Shadow returns whether the limiter is in shadow mode.
+func (r *RateLimiter) Shadow() bool {
+	return r.shadow
+}
+
+// ShadowMetrics can be implemented by Metrics to count the attempts that were
+// only allowed because the limiter is in shadow mode.
+type ShadowMetrics interface {
+	// ObserveShadowLimited is called every time an attempt over the limit is
+	// allowed because of shadow mode.
+	ObserveShadowLimited(name, policy string)
+}
+
+// observeShadowLimited reports an attempt allowed because of shadow mode.
+func (r *RateLimiter) observeShadowLimited() {
+	if metrics, ok := r.metrics.(ShadowMetrics); ok {
+		metrics.ObserveShadowLimited(r.name, r.namespace)
+	}
+}
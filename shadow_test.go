@@ -0,0 +1,91 @@
+package speedbump
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestShadowMode(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	metrics := &recordingMetrics{}
+	listener := &recordingListener{}
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	// Create limiter of 1 request/min in shadow mode.
+	limiter := NewLimiter(
+		client, PerMinuteHasher{}, 1,
+		WithNamespace("login"),
+		WithShadowMode(),
+		WithMetrics(metrics),
+		WithListener(listener),
+		WithTracerProvider(provider),
+	)
+	assert.True(t, limiter.Shadow())
+
+	decision, err := limiter.Decide(context.Background(), "test_id")
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: true, Attempted: 1, Max: 1, Remaining: 0}, withoutReceipts(decision)[0])
+	assert.False(t, decision.Shadowed())
+	assert.True(t, decision.Refundable())
+
+	// Attempts over the limit are allowed, but not counted.
+	decision, err = limiter.Decide(context.Background(), "test_id")
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: true, Limited: true, Attempted: 1, Max: 1, Remaining: 0}, decision)
+	assert.True(t, decision.Shadowed())
+	assert.False(t, decision.Refundable())
+
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, []bool{true, true, true}, metrics.decisions)
+	assert.Equal(t, 2, metrics.shadow)
+
+	assert.Equal(t, []string{"allowed", "limited", "limited"}, listener.kinds)
+	assert.False(t, listener.events[0].Shadow)
+	assert.True(t, listener.events[1].Shadow)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+	assert.NotContains(t, spanAttributes(spans[0]), AttributeShadowLimited)
+	assert.True(t, spanAttributes(spans[1])[AttributeShadowLimited].AsBool())
+	assert.Equal(t, "allowed", spanAttributes(spans[1])[AttributeDecision].AsString())
+}
+
+func TestShadowModeDisabled(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiter of 1 request/min.
+	limiter := NewLimiter(client, PerMinuteHasher{}, 1)
+	assert.False(t, limiter.Shadow())
+
+	makeNAttempts(t, limiter, "test_id", 1)
+
+	decision, err := limiter.Decide(context.Background(), "test_id")
+	require.NoError(t, err)
+	assert.Equal(t, Decision{Allowed: false, Limited: true, Attempted: 1, Max: 1, Remaining: 0}, decision)
+	assert.False(t, decision.Shadowed())
+}
+
+func TestSlogListenerShadow(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	listener := NewSlogListener(slog.New(slog.NewTextHandler(buffer, nil)))
+
+	listener.OnLimited(Event{ID: "shadow_id", Policy: "login", Shadow: true})
+
+	assert.Contains(t, buffer.String(), `level=INFO msg="rate limit exceeded in shadow mode"`)
+	assert.Contains(t, buffer.String(), "shadow=true")
+}
@@ -2,9 +2,13 @@
 package speedbump
 
 import (
+	"context"
+	"errors"
 	"strconv"
+	"sync/atomic"
 	"time"
 
+	"go.opentelemetry.io/otel/trace"
 	"gopkg.in/redis.v5"
 )
 
@@ -18,6 +22,60 @@ type RateLimiter struct {
 	// max defines the maximum number of attempts that can occur during a
 	// period.
 	max int64
+	// namespace is prepended to every key generated by the hasher. It allows
+	// multiple limiters to share a Redis server without sharing counters.
+	namespace string
+	// name identifies the limiter in metrics.
+	name string
+	// failureMode determines what happens when an attempt fails.
+	failureMode FailureMode
+	// metrics receives measurements of the limiter, if set.
+	metrics Metrics
+	// tracerProvider creates the spans of the limiter. If it is not set, the
+	// global provider is used.
+	tracerProvider trace.TracerProvider
+	// hashSpanKeys determines whether ids are hashed before being recorded in
+	// spans.
+	hashSpanKeys bool
+	// listeners receive an event for every attempt.
+	listeners []Listener
+	// shadow determines whether attempts over the limit are allowed anyway.
+	shadow bool
+	// denied caches the ids that reached the limit, if set.
+	denied *deniedCache
+	// updates holds the hasher and max of the limiter if they can be changed
+	// with Update, in which case it takes precedence over hasher and max.
+	updates *atomic.Pointer[limits]
+	// escalation bans ids that keep exceeding the limit, if set.
+	escalation *Escalation
+	// usage keeps the long-term usage of ids, if set.
+	usage *UsageHistory
+	// serverTime determines whether periods are picked with the clock of the
+	// Redis server instead of the clock of the hasher.
+	serverTime bool
+}
+
+// limits are the hasher and max of a limiter, which are always read together
+// so that an update can't be observed halfway.
+type limits struct {
+	hasher RateHasher
+	max    int64
+}
+
+// ErrNotUpdatable is returned by Update when the limiter was not created with
+// WithUpdates.
+var ErrNotUpdatable = errors.New("speedbump: limiter was not created with WithUpdates")
+
+// Option configures optional behavior of a RateLimiter.
+type Option func(*RateLimiter)
+
+// WithNamespace stores the counters of the limiter under the provided
+// namespace. Limiters with different namespaces keep separate counters even if
+// they use the same hasher and ids.
+func WithNamespace(namespace string) Option {
+	return func(r *RateLimiter) {
+		r.namespace = namespace
+	}
 }
 
 // RateHasher is an object capable of generating a hash that uniquely
@@ -34,119 +92,502 @@ type RateHasher interface {
 	Duration() time.Duration
 }
 
+// Decider makes decisions for attempts. It is implemented by RateLimiter, and
+// code that only needs decisions can depend on it so that a fake, such as the
+// one in the speedbumptest package, can be used in tests.
+type Decider interface {
+	// Decide makes and counts an attempt for an id.
+	Decide(ctx context.Context, id string) (Decision, error)
+}
+
+// Limiter is a Decider that also provides its hasher, which middleware use to
+// tell clients when to retry. It is implemented by RateLimiter and by the fake
+// in the speedbumptest package, so middleware that accept it can be tested
+// without a Redis server.
+type Limiter interface {
+	Decider
+
+	// Hasher returns the hasher the limiter counts attempts with.
+	Hasher() RateHasher
+}
+
 // NewLimiter creates a new instance of a rate limiter.
 func NewLimiter(
 	client *redis.Client,
 	hasher RateHasher,
 	max int64,
+	options ...Option,
 ) *RateLimiter {
-	return &RateLimiter{
+	limiter := &RateLimiter{
 		redisClient: client,
 		hasher:      hasher,
 		max:         max,
 	}
+
+	for _, option := range options {
+		option(limiter)
+	}
+
+	if limiter.updates != nil {
+		limiter.updates.Store(&limits{hasher: hasher, max: max})
+	}
+
+	return limiter
+}
+
+// WithUpdates allows the hasher and max of the limiter to be changed with
+// Update while it is in use.
+func WithUpdates() Option {
+	return func(r *RateLimiter) {
+		r.updates = &atomic.Pointer[limits]{}
+	}
+}
+
+// Update atomically replaces the hasher and max of the limiter. Attempts that
+// are in progress finish with the previous values. Changing the period of the
+// hasher starts new counters, since they are stored under different keys.
+//
+// The limiter must have been created with WithUpdates, otherwise
+// ErrNotUpdatable is returned.
+func (r *RateLimiter) Update(hasher RateHasher, max int64) error {
+	if r.updates == nil {
+		return ErrNotUpdatable
+	}
+
+	r.updates.Store(&limits{hasher: hasher, max: max})
+
+	// Cached denials were decided with the previous values.
+	r.denied.clear()
+
+	return nil
+}
+
+// current returns the hasher and max that currently apply to the limiter.
+func (r *RateLimiter) current() limits {
+	if r.updates != nil {
+		return *r.updates.Load()
+	}
+
+	return limits{hasher: r.hasher, max: r.max}
+}
+
+// Namespace returns the namespace of the limiter's counters.
+func (r *RateLimiter) Namespace() string {
+	return r.namespace
+}
+
+// Hasher returns the hasher used by the limiter.
+func (r *RateLimiter) Hasher() RateHasher {
+	return r.current().hasher
+}
+
+// Max returns the maximum number of attempts allowed during a period.
+func (r *RateLimiter) Max() int64 {
+	return r.current().max
+}
+
+// RetryAfter returns how long a client has to wait before the current period
+// ends and its counter is reset. If the hasher doesn't implement PeriodHasher,
+// the duration of a whole period is returned, which is an upper bound.
+//
+// Limiters created with WithServerTime ask the Redis server for the time, and
+// return the duration of a whole period if that fails.
+func (r *RateLimiter) RetryAfter() time.Duration {
+	hasher := r.Hasher()
+	if r.serverTime {
+		_, left, err := r.serverWindow(hasher)
+		if err != nil {
+			r.observeError(err)
+			return hasher.Duration()
+		}
+
+		return left
+	}
+
+	if hasher, ok := hasher.(PeriodHasher); ok {
+		return hasher.PeriodEnd().Sub(hasher.Now())
+	}
+
+	return hasher.Duration()
+}
+
+// hash generates the key of the counter for an id during the current period.
+func (r *RateLimiter) hash(id string) string {
+	return r.hashWith(r.Hasher(), id)
+}
+
+// hashWith generates the key of the counter for an id with a specific hasher.
+func (r *RateLimiter) hashWith(hasher RateHasher, id string) string {
+	if r.namespace == "" {
+		return hasher.Hash(id)
+	}
+
+	return r.namespace + ":" + hasher.Hash(id)
 }
 
 // Has returns whether the rate limiter has seen a request for a specific id
 // during the current period.
 func (r *RateLimiter) Has(id string) (bool, error) {
-	hash := r.hasher.Hash(id)
-	return r.redisClient.Exists(hash).Result()
+	return r.HasContext(context.Background(), id)
+}
+
+// HasContext is like Has, but the span created for the call is a child of any
+// span in the context.
+func (r *RateLimiter) HasContext(ctx context.Context, id string) (bool, error) {
+	_, span := r.startSpan(ctx, "Has", id)
+
+	if r.serverTime {
+		attempted, _, err := r.attempted(id)
+		endSpan(span, err)
+
+		return attempted > 0, err
+	}
+
+	hash := r.hash(id)
+
+	start := time.Now()
+	has, err := r.redisClient.Exists(hash).Result()
+	r.observeLatency("exists", start)
+
+	if err != nil {
+		r.observeError(err)
+	}
+
+	endSpan(span, err)
+
+	return has, err
 }
 
 // Attempted returns the number of attempted requests for an id in the current
 // period. Attempted does not count attempts that exceed the max requests in an
 // interval and only returns the max count after this is reached.
 func (r *RateLimiter) Attempted(id string) (int64, error) {
-	hash := r.hasher.Hash(id)
-	val, err := r.redisClient.Get(hash).Result()
+	return r.AttemptedContext(context.Background(), id)
+}
 
-	if err != nil {
-		if err == redis.Nil {
-			// Key does not exist. See: http://redis.io/commands/GET
-			return 0, nil
+// AttemptedContext is like Attempted, but the span created for the call is a
+// child of any span in the context.
+func (r *RateLimiter) AttemptedContext(ctx context.Context, id string) (int64, error) {
+	_, span := r.startSpan(ctx, "Attempted", id)
+
+	attempted, _, err := r.attempted(id)
+	endSpan(span, err)
+
+	return attempted, err
+}
+
+// attempted retrieves the counter for an id, along with the max that applies
+// to it.
+func (r *RateLimiter) attempted(id string) (int64, int64, error) {
+	current := r.current()
+
+	if r.serverTime {
+		result, err := r.runServerTime(current, id, "get")
+		if err != nil {
+			r.observeError(err)
 		}
-		return 0, err
+
+		return result.attempted, result.max, err
 	}
 
+	hash := r.hashWith(current.hasher, id)
+
+	// Keys that don't exist are returned as nil.
+	// See: http://redis.io/commands/MGET
+	start := time.Now()
+	vals, err := r.redisClient.MGet(hash, r.overrideKey(id)).Result()
+	r.observeLatency("get", start)
+
 	if err != nil {
-		return 0, err
+		r.observeError(err)
+		return 0, 0, err
+	}
+
+	max, err := limitFor(vals[1], current.max)
+	if err != nil {
+		return 0, 0, err
 	}
 
-	return strconv.ParseInt(val, 10, 64)
+	val, exists := vals[0].(string)
+	if !exists {
+		return 0, max, nil
+	}
+
+	attempted, err := strconv.ParseInt(val, 10, 64)
+
+	return attempted, max, err
 }
 
 // Left returns the number of remaining requests for id during a current
 // period.
 func (r *RateLimiter) Left(id string) (int64, error) {
+	return r.LeftContext(context.Background(), id)
+}
+
+// LeftContext is like Left, but the span created for the call is a child of
+// any span in the context.
+func (r *RateLimiter) LeftContext(ctx context.Context, id string) (int64, error) {
+	_, span := r.startSpan(ctx, "Left", id)
+
 	// Retrieve attempted count.
-	attempted, err := r.Attempted(id)
+	attempted, max, err := r.attempted(id)
+	endSpan(span, err)
+
 	if err != nil {
 		return 0, err
 	}
 
+	return left(attempted, max), nil
+}
+
+// left computes the number of remaining requests from the attempted count.
+func left(attempted, max int64) int64 {
 	// Left is max minus attempted.
-	left := r.max - attempted
+	left := max - attempted
 	if left < 0 {
-		return 0, nil
+		return 0
 	}
 
-	return left, nil
+	return left
 }
 
 // Attempt attempts to perform a request for an id and returns whether it was
 // successful or not.
+//
+// If the attempt fails because of an error, the result depends on the failure
+// mode of the limiter. See WithFailureMode.
 func (r *RateLimiter) Attempt(id string) (bool, error) {
-	// Create hash from id
-	hash := r.hasher.Hash(id)
+	return r.AttemptContext(context.Background(), id)
+}
 
-	// Get value for hash in Redis. If redis.Nil is returned, key does not
-	// exist.
-	exists := true
+// AttemptContext is like Attempt, but the span created for the attempt is a
+// child of any span in the context, such as the span of an incoming request.
+func (r *RateLimiter) AttemptContext(ctx context.Context, id string) (bool, error) {
+	decision, err := r.Decide(ctx, id)
+
+	return decision.Allowed, err
+}
+
+// Decision describes the outcome of an attempt.
+type Decision struct {
+	// Allowed is whether the attempt was allowed.
+	Allowed bool
+	// Limited is whether the attempt exceeded the limit. It is only different
+	// from !Allowed in shadow mode, where attempts over the limit are allowed
+	// anyway, and when the failure mode decided the attempt.
+	Limited bool
+	// Attempted is the value of the counter after the attempt.
+	Attempted int64
+	// Max is the maximum number of attempts that applies to the id.
+	Max int64
+	// Remaining is the number of attempts left in the period.
+	Remaining int64
+
+	// receipt is set if the attempt was counted, so that it can be refunded.
+	receipt *receipt
+}
+
+// Shadowed returns whether the attempt was only allowed because the limiter
+// is in shadow mode.
+func (d Decision) Shadowed() bool {
+	return d.Allowed && d.Limited
+}
+
+// Decide is like AttemptContext, but it returns the details of the decision,
+// such as whether the attempt was only allowed because of shadow mode.
+func (r *RateLimiter) Decide(ctx context.Context, id string) (Decision, error) {
+	return r.decide(ctx, "Attempt", id, true)
+}
+
+// decide makes the decision of an attempt, which is counted if count is true,
+// and reports it.
+func (r *RateLimiter) decide(ctx context.Context, operation, id string, count bool) (Decision, error) {
+	_, span := r.startSpan(ctx, operation, id)
+
+	start := time.Now()
+	result, err := r.attempt(id, count)
 
-	val, err := r.redisClient.Get(hash).Result()
 	if err != nil {
-		if err == redis.Nil {
-			// Key does not exist. See: http://redis.io/commands/GET
-			exists = false
-		} else {
-			return false, err
+		// Record the error even if the failure mode hides it from the caller.
+		r.observeError(err)
+		recordError(span, err)
+		recordFailure(span, r.failureMode)
+	}
+
+	decision, event, err := r.finish(id, start, result, err)
+	r.recordDecisions(decision)
+	if event.Err == nil {
+		if decision.Shadowed() {
+			recordShadowLimited(span)
 		}
+
+		recordDecision(span, decision.Allowed, decision.Remaining)
+	}
+
+	span.End()
+	r.notify(event, result.ok)
+
+	return decision, err
+}
+
+// finish turns the result of an attempt into a decision, applying the failure
+// mode and shadow mode, and reports it to the metrics of the limiter. It also
+// returns the event for listeners, which the caller has to send.
+func (r *RateLimiter) finish(
+	id string,
+	start time.Time,
+	result attemptResult,
+	err error,
+) (Decision, Event, error) {
+	decision := Decision{
+		Allowed:   result.ok,
+		Attempted: result.attempted,
+		Max:       result.max,
+		Remaining: left(result.attempted, result.max),
+	}
+	event := Event{
+		Name:      r.name,
+		Policy:    r.namespace,
+		ID:        id,
+		Attempted: result.attempted,
+		Max:       result.max,
+		Remaining: decision.Remaining,
+		Time:      start,
+		Duration:  time.Since(start),
+	}
+
+	if err != nil {
+		event.Err = err
+		event.FailureMode = r.failureMode
+		decision.Allowed, err = r.fallback(err)
+
+		return decision, event, err
+	}
+
+	decision.Limited = !result.ok
+
+	if result.key != "" {
+		decision.receipt = &receipt{id: id, key: result.key, cost: result.cost}
+	}
+
+	if decision.Limited && r.shadow {
+		// The rejection is reported, but the attempt is allowed anyway.
+		decision.Allowed = true
+		event.Shadow = true
+		r.observeShadowLimited()
+	}
+
+	r.observeDecision(decision.Allowed)
+
+	return decision, event, nil
+}
+
+// attempt performs an attempt without handling errors. It returns the value of
+// the counter after the attempt and the max that applies to the id. If count
+// is false, the attempt is only checked against the limit, without counting
+// it.
+func (r *RateLimiter) attempt(id string, count bool) (attemptResult, error) {
+	// Create hash from id. The hasher and max are read once, so that the whole
+	// attempt uses the same values even if they are updated meanwhile.
+	current := r.current()
+	if r.serverTime {
+		return r.attemptServerTime(current, id, count)
+	}
+
+	hash := r.hashWith(current.hasher, id)
+
+	// Ids that are known to be over the limit until the end of the period are
+	// denied without calling the Redis server.
+	periodHasher, cacheable := current.hasher.(PeriodHasher)
+	cacheable = cacheable && r.denied != nil
+
+	if cacheable {
+		if entry, ok := r.denied.get(id, periodHasher.Now()); ok {
+			return attemptResult{attempted: entry.attempted, max: entry.max, cached: true}, nil
+		}
+	}
+
+	// Get the value for hash, and the ban and override for the id in Redis.
+	// Keys that don't exist are returned as nil.
+	// See: http://redis.io/commands/MGET
+	start := time.Now()
+	vals, err := r.redisClient.MGet(hash, r.banKey(id), r.overrideKey(id)).Result()
+	r.observeLatency("get", start)
+
+	if err != nil {
+		return attemptResult{}, err
+	}
+
+	max, err := limitFor(vals[2], current.max)
+	if err != nil {
+		return attemptResult{}, err
+	}
+
+	// Banned ids are denied without being counted.
+	if vals[1] != nil {
+		return attemptResult{attempted: max, max: max, banned: true}, nil
 	}
 
 	// If key exists and is >= max requests, return false.
-	if exists {
+	var attempted int64
+	if val, exists := vals[0].(string); exists {
 		intVal, err := strconv.ParseInt(val, 10, 64)
 		if err != nil {
-			return false, err
+			return attemptResult{}, err
 		}
 
-		if intVal >= r.max {
-			return false, nil
+		attempted = intVal
+
+		if intVal >= max {
+			// Going over the limit is an offence when the limiter escalates.
+			if err := r.escalate(id); err != nil {
+				return attemptResult{}, err
+			}
+
+			if cacheable {
+				r.denied.add(id, deniedEntry{
+					until:     periodHasher.PeriodEnd(),
+					attempted: intVal,
+					max:       max,
+				}, periodHasher.Now())
+			}
+
+			return attemptResult{attempted: intVal, max: max}, nil
 		}
 	}
 
-	// Otherwise, increment and expire key for hasher.Duration(). Note, we call
-	// Expire even when key already exists to avoid race condition where key
-	// expires between prior existence check and this Incr call.
+	if !count {
+		return attemptResult{attempted: attempted, max: max, ok: true}, nil
+	}
+
+	// Otherwise, increment and expire key for hasher.Duration(), in
+	// milliseconds so that windows that are not whole seconds expire on time.
+	// Note, we call PExpire even when key already exists to avoid race
+	// condition where key expires between prior existence check and this Incr
+	// call.
 	//
 	// See: http://redis.io/commands/INCR
 	// See: http://redis.io/commands/INCR#pattern-rate-limiter-1
+	var incr *redis.IntCmd
+
+	start = time.Now()
 	err = r.redisClient.Watch(func(rx *redis.Tx) error {
 		_, err := rx.Pipelined(func(pipe *redis.Pipeline) error {
-			if err := pipe.Incr(hash).Err(); err != nil {
+			incr = pipe.Incr(hash)
+			if err := incr.Err(); err != nil {
 				return err
 			}
 
-			return pipe.Expire(hash, r.hasher.Duration()).Err()
+			return pipe.PExpire(hash, current.hasher.Duration()).Err()
 		})
 
 		return err
 	})
+	r.observeLatency("incr", start)
 
 	if err != nil {
-		return false, err
+		return attemptResult{}, err
 	}
 
-	return true, nil
+	return attemptResult{attempted: incr.Val(), max: max, ok: true, key: hash, cost: 1}, nil
 }
@@ -47,6 +47,51 @@ func TestNewLimiter(t *testing.T) {
 	}, *actual)
 }
 
+func TestNamespace(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create two limiters of 1 request/min with different namespaces.
+	hasher := PerMinuteHasher{}
+	login := NewLimiter(client, hasher, 1, WithNamespace("login"))
+	search := NewLimiter(client, hasher, 1, WithNamespace("search"))
+	assert.Equal(t, "login", login.Namespace())
+
+	// Each limiter keeps its own counter for the same id.
+	ok, err := login.Attempt("127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = search.Attempt("127.0.0.1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = login.Attempt("127.0.0.1")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Counters are stored under the namespace.
+	exists, err := client.Exists("login:" + hasher.Hash("127.0.0.1")).Result()
+	require.NoError(t, err)
+	assert.True(t, exists)
+}
+
+func TestRetryAfter(t *testing.T) {
+	mock := clock.NewMock()
+	mock.Add(15 * time.Second)
+
+	limiter := NewLimiter(nil, PerMinuteHasher{Clock: mock}, 5)
+	assert.Equal(t, 45*time.Second, limiter.RetryAfter())
+
+	// Hashers without fixed periods return the whole duration.
+	limiter = NewLimiter(nil, durationHasher{}, 5)
+	assert.Equal(t, time.Minute, limiter.RetryAfter())
+}
+
+// durationHasher is a RateHasher that does not implement PeriodHasher.
+type durationHasher struct{}
+
+func (durationHasher) Hash(id string) string   { return id }
+func (durationHasher) Duration() time.Duration { return time.Minute }
+
 func ExampleNewLimiter() {
 	// Create a Redis client.
 	client := createClient()
@@ -61,6 +106,52 @@ func ExampleNewLimiter() {
 	// Output: true <nil>
 }
 
+func TestUpdate(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+	// Create limiters of 2 requests/min with mock clock.
+	mock := clock.NewMock()
+	hasher := PerMinuteHasher{Clock: mock}
+
+	static := NewLimiter(client, hasher, 2)
+	assert.Equal(t, ErrNotUpdatable, static.Update(hasher, 3))
+
+	limiter := NewLimiter(client, hasher, 2, WithUpdates())
+	assert.Equal(t, int64(2), limiter.Max())
+
+	makeNAttempts(t, limiter, "test_id", 2)
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+
+	// Raising the max applies to the current counter.
+	require.NoError(t, limiter.Update(hasher, 3))
+	assert.Equal(t, int64(3), limiter.Max())
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	left, err := limiter.Left("test_id")
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), left)
+
+	// Changing the period starts new counters.
+	window := WindowHasher{Window: 10 * time.Second, Clock: mock}
+	require.NoError(t, limiter.Update(window, 1))
+	assert.Equal(t, window, limiter.Hasher())
+	assert.Equal(t, 10*time.Second, limiter.RetryAfter())
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
 func TestHas(t *testing.T) {
 	// Create Redis client and defer DB teardown.
 	client := createClient()
@@ -336,3 +427,29 @@ func TestAttemptedLeft(t *testing.T) {
 
 	}
 }
+
+func TestAttemptSubSecondWindow(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	// Windows that are not whole seconds expire their counters on time, instead
+	// of being truncated to seconds.
+	for _, window := range []time.Duration{500 * time.Millisecond, 1500 * time.Millisecond} {
+		hasher := WindowHasher{Clock: clock.NewMock(), Window: window}
+		limiter := NewLimiter(client, hasher, 1)
+		id := window.String()
+
+		ok, err := limiter.Attempt(id)
+		require.NoError(t, err)
+		assert.True(t, ok)
+
+		ok, err = limiter.Attempt(id)
+		require.NoError(t, err)
+		assert.False(t, ok)
+
+		ttl, err := client.PTTL(hasher.Hash(id)).Result()
+		require.NoError(t, err)
+		assert.InDelta(t, float64(window), float64(ttl), float64(100*time.Millisecond))
+	}
+}
@@ -0,0 +1,48 @@
+package speedbumptest
+
+import (
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/facebookgo/clock"
+)
+
+// Epoch is the time at which clocks created by NewClock start. It is the start
+// of a day, so that it is also the start of every built-in period.
+var Epoch = time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// NewClock creates a mock clock that starts at Epoch. It can be shared by the
+// hashers of fake and real limiters, and moved with Advance, AdvanceTo and
+// NextPeriod.
+func NewClock() *clock.Mock {
+	mock := clock.NewMock()
+	AdvanceTo(mock, Epoch)
+
+	return mock
+}
+
+// Advance moves a mock clock forward by d.
+func Advance(mock *clock.Mock, d time.Duration) {
+	mock.Add(d)
+}
+
+// AdvanceTo moves a mock clock forward to t. Clocks can't go back, so nothing
+// happens if t is not after the current time of the clock.
+func AdvanceTo(mock *clock.Mock, t time.Time) {
+	if d := t.Sub(mock.Now()); d > 0 {
+		mock.Add(d)
+	}
+}
+
+// NextPeriod moves a mock clock forward to the start of the next period of a
+// hasher, which expires the counters of the current one. The hasher should use
+// the clock. If it doesn't implement speedbump.PeriodHasher, the clock is moved
+// by the duration of a whole period.
+func NextPeriod(mock *clock.Mock, hasher speedbump.RateHasher) {
+	if hasher, ok := hasher.(speedbump.PeriodHasher); ok {
+		AdvanceTo(mock, hasher.PeriodEnd())
+		return
+	}
+
+	mock.Add(hasher.Duration())
+}
@@ -0,0 +1,42 @@
+package speedbumptest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClock(t *testing.T) {
+	mock := NewClock()
+	assert.True(t, Epoch.Equal(mock.Now()))
+
+	Advance(mock, 30*time.Second)
+	assert.True(t, Epoch.Add(30*time.Second).Equal(mock.Now()))
+
+	// Clocks never go back.
+	AdvanceTo(mock, Epoch)
+	assert.True(t, Epoch.Add(30*time.Second).Equal(mock.Now()))
+
+	NextPeriod(mock, speedbump.PerMinuteHasher{Clock: mock})
+	assert.True(t, Epoch.Add(time.Minute).Equal(mock.Now()))
+
+	NextPeriod(mock, speedbump.WindowHasher{Clock: mock, Window: 5 * time.Minute})
+	assert.True(t, Epoch.Add(5*time.Minute).Equal(mock.Now()))
+
+	// Hashers without periods move by a whole period.
+	NextPeriod(mock, fixedHasher{})
+	assert.True(t, Epoch.Add(6*time.Minute).Equal(mock.Now()))
+}
+
+// fixedHasher is a hasher that doesn't implement speedbump.PeriodHasher.
+type fixedHasher struct{}
+
+func (fixedHasher) Hash(id string) string {
+	return id
+}
+
+func (fixedHasher) Duration() time.Duration {
+	return time.Minute
+}
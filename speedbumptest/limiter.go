@@ -0,0 +1,263 @@
+// Package speedbumptest provides fakes and helpers for testing code that uses
+// speedbump, without a Redis server.
+package speedbumptest
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/etcinit/speedbump"
+)
+
+// Call describes a call made to a Limiter, which rules use to decide it.
+type Call struct {
+	// N is the position of the call among all the calls to the limiter,
+	// starting at 1.
+	N int
+	// ID is the id of the attempt.
+	ID string
+	// Attempted is the number of attempts already counted for the id in the
+	// current period, before this one.
+	Attempted int64
+}
+
+// Rule scripts the outcome of calls to a Limiter. It returns the outcome of
+// the call, or Default to leave it to the next rule or the limit.
+type Rule func(call Call) Outcome
+
+// Outcome is the outcome of a call decided by a rule.
+type Outcome int
+
+const (
+	// Default leaves the call to the next rule, or to the limit if no rule
+	// decides it.
+	Default Outcome = iota
+	// Allow allows and counts the call, even if it is over the limit.
+	Allow
+	// Deny denies the call without counting it.
+	Deny
+)
+
+// Limiter is a fake rate limiter that keeps its counters in memory. It counts
+// attempts in the periods of its hasher like speedbump.RateLimiter, so that
+// moving the clock of the hasher expires them, and its outcomes can be
+// scripted with rules.
+//
+//	clock := speedbumptest.NewClock()
+//	limiter := speedbumptest.NewLimiter(speedbump.PerMinuteHasher{Clock: clock}, 5).
+//	  DenyCall(3).
+//	  DenyID("banned")
+//
+// Limiter implements speedbump.Limiter, so it can be used with the middleware
+// of Speedbump, and is safe for concurrent use.
+type Limiter struct {
+	hasher    speedbump.RateHasher
+	max       int64
+	mu        sync.Mutex
+	calls     int
+	counters  map[string]int64
+	rules     []Rule
+	err       error
+	listeners []speedbump.Listener
+}
+
+var _ speedbump.Limiter = (*Limiter)(nil)
+
+// NewLimiter creates a fake limiter that allows max attempts per period of the
+// hasher.
+func NewLimiter(hasher speedbump.RateHasher, max int64) *Limiter {
+	return &Limiter{hasher: hasher, max: max, counters: map[string]int64{}}
+}
+
+// Rule adds a rule to the limiter. Rules are evaluated in the order they were
+// added, and the first one that doesn't return Default decides the call.
+func (l *Limiter) Rule(rule Rule) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rules = append(l.rules, rule)
+
+	return l
+}
+
+// DenyCall denies the nth call to the limiter, counting from 1, whatever its
+// id.
+func (l *Limiter) DenyCall(n int) *Limiter {
+	return l.Rule(func(call Call) Outcome {
+		if call.N == n {
+			return Deny
+		}
+
+		return Default
+	})
+}
+
+// DenyID always denies attempts for an id.
+func (l *Limiter) DenyID(id string) *Limiter {
+	return l.Rule(func(call Call) Outcome {
+		if call.ID == id {
+			return Deny
+		}
+
+		return Default
+	})
+}
+
+// AllowID always allows attempts for an id, even over the limit.
+func (l *Limiter) AllowID(id string) *Limiter {
+	return l.Rule(func(call Call) Outcome {
+		if call.ID == id {
+			return Allow
+		}
+
+		return Default
+	})
+}
+
+// Fail makes every call return err, as if the Redis server was unavailable,
+// until it is called again with nil.
+func (l *Limiter) Fail(err error) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.err = err
+
+	return l
+}
+
+// Listen sends an event for every call to a listener, such as a Recorder, like
+// speedbump.WithListener.
+func (l *Limiter) Listen(listener speedbump.Listener) *Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.listeners = append(l.listeners, listener)
+
+	return l
+}
+
+// Hasher returns the hasher of the limiter.
+func (l *Limiter) Hasher() speedbump.RateHasher {
+	return l.hasher
+}
+
+// Max returns the maximum number of attempts allowed during a period.
+func (l *Limiter) Max() int64 {
+	return l.max
+}
+
+// Calls returns the number of calls made to the limiter.
+func (l *Limiter) Calls() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.calls
+}
+
+// Attempted returns the number of attempts counted for an id during the
+// current period.
+func (l *Limiter) Attempted(id string) int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.counters[l.hasher.Hash(id)]
+}
+
+// Reset removes the counters of every id and the number of calls, but keeps
+// the rules.
+func (l *Limiter) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.calls = 0
+	l.counters = map[string]int64{}
+}
+
+// Attempt attempts a request for an id, like speedbump.RateLimiter.Attempt.
+func (l *Limiter) Attempt(id string) (bool, error) {
+	return l.AttemptContext(context.Background(), id)
+}
+
+// AttemptContext is like Attempt, but it takes a context.
+func (l *Limiter) AttemptContext(ctx context.Context, id string) (bool, error) {
+	decision, err := l.Decide(ctx, id)
+
+	return decision.Allowed, err
+}
+
+// Decide makes the decision of an attempt for an id, like
+// speedbump.RateLimiter.Decide. Attempts are allowed and counted while the id
+// is under the limit, unless a rule decides otherwise.
+func (l *Limiter) Decide(ctx context.Context, id string) (speedbump.Decision, error) {
+	start := time.Now()
+
+	l.mu.Lock()
+
+	l.calls++
+	key := l.hasher.Hash(id)
+	call := Call{N: l.calls, ID: id, Attempted: l.counters[key]}
+	event := speedbump.Event{ID: id, Max: l.max, Time: start}
+	listeners := l.listeners
+
+	if l.err != nil {
+		err := l.err
+		l.mu.Unlock()
+
+		event.Err = err
+		event.Attempted = call.Attempted
+		event.Remaining = remaining(call.Attempted, l.max)
+		for _, listener := range listeners {
+			listener.OnError(event)
+		}
+
+		return speedbump.Decision{}, err
+	}
+
+	outcome := Default
+	for _, rule := range l.rules {
+		if outcome = rule(call); outcome != Default {
+			break
+		}
+	}
+
+	allowed := outcome == Allow || (outcome == Default && call.Attempted < l.max)
+	attempted := call.Attempted
+	if allowed {
+		attempted++
+		l.counters[key] = attempted
+	}
+
+	l.mu.Unlock()
+
+	decision := speedbump.Decision{
+		Allowed:   allowed,
+		Limited:   !allowed,
+		Attempted: attempted,
+		Max:       l.max,
+		Remaining: remaining(attempted, l.max),
+	}
+
+	event.Attempted = attempted
+	event.Remaining = decision.Remaining
+	event.Duration = time.Since(start)
+
+	for _, listener := range listeners {
+		if allowed {
+			listener.OnAllowed(event)
+		} else {
+			listener.OnLimited(event)
+		}
+	}
+
+	return decision, nil
+}
+
+// remaining returns the number of attempts left in a period.
+func remaining(attempted, max int64) int64 {
+	if attempted >= max {
+		return 0
+	}
+
+	return max - attempted
+}
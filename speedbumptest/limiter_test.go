@@ -0,0 +1,112 @@
+package speedbumptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	mock := NewClock()
+	hasher := speedbump.PerMinuteHasher{Clock: mock}
+	limiter := NewLimiter(hasher, 2)
+
+	ctx := context.Background()
+
+	first, err := limiter.Decide(ctx, "test_id")
+	require.NoError(t, err)
+	assert.Equal(t, speedbump.Decision{Allowed: true, Attempted: 1, Max: 2, Remaining: 1}, first)
+
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	denied, err := limiter.Decide(ctx, "test_id")
+	require.NoError(t, err)
+	assert.Equal(t, speedbump.Decision{Limited: true, Attempted: 2, Max: 2}, denied)
+
+	assert.Equal(t, 3, limiter.Calls())
+	assert.Equal(t, int64(2), limiter.Attempted("test_id"))
+
+	// Moving the clock to the next period expires the counters.
+	NextPeriod(mock, hasher)
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, int64(1), limiter.Attempted("test_id"))
+
+	limiter.Reset()
+	assert.Equal(t, 0, limiter.Calls())
+	assert.Equal(t, int64(0), limiter.Attempted("test_id"))
+}
+
+func TestLimiterRules(t *testing.T) {
+	limiter := NewLimiter(speedbump.PerMinuteHasher{Clock: NewClock()}, 10).
+		DenyCall(3).
+		DenyID("banned").
+		AllowID("admin")
+
+	allowed := []bool{}
+	for _, id := range []string{"a", "b", "c", "banned", "a"} {
+		ok, err := limiter.Attempt(id)
+		require.NoError(t, err)
+		allowed = append(allowed, ok)
+	}
+
+	assert.Equal(t, []bool{true, true, false, false, true}, allowed)
+	assert.Equal(t, int64(0), limiter.Attempted("c"))
+
+	// Allowed ids are never limited, but they are still counted.
+	limiter = NewLimiter(speedbump.PerMinuteHasher{Clock: NewClock()}, 1).AllowID("admin")
+
+	for i := 0; i < 3; i++ {
+		ok, err := limiter.Attempt("admin")
+		require.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	assert.Equal(t, int64(3), limiter.Attempted("admin"))
+
+	// Custom rules see the call.
+	limiter = NewLimiter(speedbump.PerMinuteHasher{Clock: NewClock()}, 10).
+		Rule(func(call Call) Outcome {
+			if call.Attempted >= 1 {
+				return Deny
+			}
+
+			return Default
+		})
+
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestLimiterFail(t *testing.T) {
+	recorder := NewRecorder()
+	failure := errors.New("connection refused")
+	limiter := NewLimiter(speedbump.PerMinuteHasher{Clock: NewClock()}, 1).
+		Listen(recorder).
+		Fail(failure)
+
+	_, err := limiter.Decide(context.Background(), "test_id")
+	assert.Equal(t, failure, err)
+
+	limiter.Fail(nil)
+
+	ok, err := limiter.Attempt("test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.Equal(t, []Kind{Error, Allowed}, recorder.Kinds())
+	assert.Equal(t, failure, recorder.Records()[0].Event.Err)
+}
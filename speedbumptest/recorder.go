@@ -0,0 +1,132 @@
+package speedbumptest
+
+import (
+	"sync"
+
+	"github.com/etcinit/speedbump"
+)
+
+// Kind is the kind of a recorded decision, which matches the listener method
+// that received it.
+type Kind string
+
+const (
+	// Allowed is the kind of decisions that allowed an attempt.
+	Allowed Kind = "allowed"
+	// Limited is the kind of decisions that denied an attempt, or that would
+	// have in shadow mode.
+	Limited Kind = "limited"
+	// Error is the kind of attempts that failed with an error.
+	Error Kind = "error"
+	// Fallback is the kind of attempts that failed and were decided by the
+	// failure mode.
+	Fallback Kind = "fallback"
+)
+
+// Record is a decision captured by a Recorder.
+type Record struct {
+	// Kind is the kind of the decision.
+	Kind Kind
+	// Event has the details of the decision.
+	Event speedbump.Event
+}
+
+// Recorder is a speedbump.Listener that captures every decision, so that
+// tests can assert on them. It can be added to a real limiter with
+// speedbump.WithListener, or to a fake one with Limiter.Listen.
+//
+// Recorder is safe for concurrent use, and its zero value is ready to use.
+type Recorder struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+// NewRecorder creates an empty recorder.
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+// OnAllowed records an allowed attempt.
+func (r *Recorder) OnAllowed(event speedbump.Event) {
+	r.record(Allowed, event)
+}
+
+// OnLimited records a denied attempt.
+func (r *Recorder) OnLimited(event speedbump.Event) {
+	r.record(Limited, event)
+}
+
+// OnError records a failed attempt.
+func (r *Recorder) OnError(event speedbump.Event) {
+	r.record(Error, event)
+}
+
+// OnFallback records an attempt decided by the failure mode.
+func (r *Recorder) OnFallback(event speedbump.Event) {
+	r.record(Fallback, event)
+}
+
+// record adds a decision to the recorder.
+func (r *Recorder) record(kind Kind, event speedbump.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = append(r.records, Record{Kind: kind, Event: event})
+}
+
+// Records returns every decision captured so far, in the order they were made.
+func (r *Recorder) Records() []Record {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]Record(nil), r.records...)
+}
+
+// Kinds returns the kind of every decision captured so far, which is handy to
+// compare against an expected sequence.
+func (r *Recorder) Kinds() []Kind {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	kinds := make([]Kind, len(r.records))
+	for i, record := range r.records {
+		kinds[i] = record.Kind
+	}
+
+	return kinds
+}
+
+// Count returns the number of decisions of a kind, optionally only for the
+// provided ids.
+func (r *Recorder) Count(kind Kind, ids ...string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	for _, record := range r.records {
+		if record.Kind == kind && (len(ids) == 0 || contains(ids, record.Event.ID)) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// Reset removes every captured decision.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.records = nil
+}
+
+// contains returns whether values contains value.
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
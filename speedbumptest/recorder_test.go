@@ -0,0 +1,36 @@
+package speedbumptest
+
+import (
+	"testing"
+
+	"github.com/etcinit/speedbump"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	recorder := NewRecorder()
+	limiter := NewLimiter(speedbump.PerMinuteHasher{Clock: NewClock()}, 1).Listen(recorder)
+
+	for _, id := range []string{"a", "a", "b"} {
+		_, err := limiter.Attempt(id)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []Kind{Allowed, Limited, Allowed}, recorder.Kinds())
+	assert.Equal(t, 2, recorder.Count(Allowed))
+	assert.Equal(t, 1, recorder.Count(Allowed, "a"))
+	assert.Equal(t, 1, recorder.Count(Limited, "a", "b"))
+
+	records := recorder.Records()
+	require.Len(t, records, 3)
+	assert.Equal(t, "a", records[1].Event.ID)
+	assert.Equal(t, int64(1), records[1].Event.Attempted)
+	assert.Equal(t, int64(0), records[1].Event.Remaining)
+
+	// A recorder can also listen to real limiters.
+	var _ speedbump.Listener = recorder
+
+	recorder.Reset()
+	assert.Empty(t, recorder.Records())
+}
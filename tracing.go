@@ -0,0 +1,154 @@
+package speedbump
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer used by limiters.
+const tracerName = "github.com/etcinit/speedbump"
+
+// Attributes recorded on the spans created by limiters.
+const (
+	// AttributeName is the name of the limiter.
+	AttributeName = attribute.Key("speedbump.name")
+	// AttributePolicy is the namespace of the limiter.
+	AttributePolicy = attribute.Key("speedbump.policy")
+	// AttributeKey is the id being limited, hashed if the limiter was created
+	// with WithHashedSpanKeys.
+	AttributeKey = attribute.Key("speedbump.key")
+	// AttributeMax is the maximum number of attempts per period.
+	AttributeMax = attribute.Key("speedbump.max")
+	// AttributeDecision is "allowed" or "denied".
+	AttributeDecision = attribute.Key("speedbump.decision")
+	// AttributeRemaining is the number of attempts left in the period.
+	AttributeRemaining = attribute.Key("speedbump.remaining")
+	// AttributeFailureMode is the failure mode applied when the store failed.
+	AttributeFailureMode = attribute.Key("speedbump.failure_mode")
+	// AttributeCount is the number of ids in a batch of attempts.
+	AttributeCount = attribute.Key("speedbump.count")
+	// AttributeShadowLimited is set when an attempt over the limit was allowed
+	// because the limiter is in shadow mode.
+	AttributeShadowLimited = attribute.Key("speedbump.shadow_limited")
+)
+
+// WithTracerProvider creates spans with the provided provider instead of the
+// global one returned by otel.GetTracerProvider.
+func WithTracerProvider(provider trace.TracerProvider) Option {
+	return func(r *RateLimiter) {
+		r.tracerProvider = provider
+	}
+}
+
+// WithHashedSpanKeys records a SHA-256 hash of ids in spans instead of the ids
+// themselves, which keeps IP addresses or API keys out of traces while still
+// allowing spans for the same id to be correlated.
+func WithHashedSpanKeys() Option {
+	return func(r *RateLimiter) {
+		r.hashSpanKeys = true
+	}
+}
+
+// AddDecisionEvent adds an event with the decision of the limiter to the span
+// in the context. Middleware use it to record decisions on the span of the
+// incoming request. The name and policy of the limiter are only recorded if it
+// is a RateLimiter.
+func AddDecisionEvent(ctx context.Context, limiter Decider, allowed bool) {
+	var name, policy string
+	if r, ok := limiter.(*RateLimiter); ok {
+		name, policy = r.name, r.namespace
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("speedbump.decision", trace.WithAttributes(
+		AttributeName.String(name),
+		AttributePolicy.String(policy),
+		AttributeDecision.String(decisionString(allowed)),
+	))
+}
+
+// startSpan starts a span for a call to the limiter.
+func (r *RateLimiter) startSpan(ctx context.Context, operation, id string) (context.Context, trace.Span) {
+	return r.startSpanWith(ctx, operation, AttributeKey.String(r.spanKey(id)))
+}
+
+// startSpanWith starts a span for a call to the limiter with additional
+// attributes.
+func (r *RateLimiter) startSpanWith(
+	ctx context.Context,
+	operation string,
+	attributes ...attribute.KeyValue,
+) (context.Context, trace.Span) {
+	provider := r.tracerProvider
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+
+	return provider.Tracer(tracerName).Start(ctx, "speedbump."+operation, trace.WithAttributes(append(
+		[]attribute.KeyValue{
+			AttributeName.String(r.name),
+			AttributePolicy.String(r.namespace),
+			AttributeMax.Int64(r.Max()),
+		},
+		attributes...,
+	)...))
+}
+
+// spanKey returns the value recorded for an id in spans.
+func (r *RateLimiter) spanKey(id string) string {
+	if !r.hashSpanKeys {
+		return id
+	}
+
+	sum := sha256.Sum256([]byte(id))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// recordDecision records the outcome of an attempt on a span.
+func recordDecision(span trace.Span, allowed bool, remaining int64) {
+	span.SetAttributes(
+		AttributeDecision.String(decisionString(allowed)),
+		AttributeRemaining.Int64(remaining),
+	)
+}
+
+// recordShadowLimited records that an attempt was only allowed because of
+// shadow mode.
+func recordShadowLimited(span trace.Span) {
+	span.SetAttributes(AttributeShadowLimited.Bool(true))
+}
+
+// recordFailure records that the failure mode was applied to an attempt.
+func recordFailure(span trace.Span, mode FailureMode) {
+	span.SetAttributes(AttributeFailureMode.String(mode.String()))
+}
+
+// recordError records an error on a span.
+func recordError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// endSpan ends a span, recording the error if there was one.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		recordError(span, err)
+	}
+
+	span.End()
+}
+
+// decisionString converts a decision into a label.
+func decisionString(allowed bool) string {
+	if allowed {
+		return "allowed"
+	}
+
+	return "denied"
+}
@@ -0,0 +1,90 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// spanAttributes collects the attributes of a span into a map.
+func spanAttributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range span.Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+
+	return attributes
+}
+
+func TestAttemptSpans(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	// Create limiter of 1 request/min.
+	limiter := NewLimiter(
+		client, PerMinuteHasher{}, 1,
+		WithNamespace("login"),
+		WithTracerProvider(provider),
+	)
+
+	// Attempts are children of the span in the context.
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "request")
+	_, err := limiter.AttemptContext(ctx, "test_id")
+	require.NoError(t, err)
+	_, err = limiter.AttemptContext(ctx, "test_id")
+	require.NoError(t, err)
+	AddDecisionEvent(ctx, limiter, false)
+	parent.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	allowed := spanAttributes(spans[0])
+	assert.Equal(t, "speedbump.Attempt", spans[0].Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, "login", allowed[AttributePolicy].AsString())
+	assert.Equal(t, "test_id", allowed[AttributeKey].AsString())
+	assert.Equal(t, "allowed", allowed[AttributeDecision].AsString())
+	assert.Equal(t, int64(0), allowed[AttributeRemaining].AsInt64())
+
+	denied := spanAttributes(spans[1])
+	assert.Equal(t, "denied", denied[AttributeDecision].AsString())
+
+	require.Len(t, spans[2].Events(), 1)
+	assert.Equal(t, "speedbump.decision", spans[2].Events()[0].Name)
+}
+
+func TestAttemptSpansErrors(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	limiter := NewLimiter(
+		createBrokenClient(), PerMinuteHasher{}, 1,
+		WithTracerProvider(provider),
+		WithHashedSpanKeys(),
+		WithFailureMode(FailOpen),
+	)
+
+	ok, err := limiter.AttemptContext(context.Background(), "test_id")
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+
+	attributes := spanAttributes(spans[0])
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Equal(t, "open", attributes[AttributeFailureMode].AsString())
+	assert.Len(t, attributes[AttributeKey].AsString(), 64)
+	assert.NotEqual(t, "test_id", attributes[AttributeKey].AsString())
+}
@@ -0,0 +1,278 @@
+package speedbump
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"gopkg.in/redis.v5"
+)
+
+// DefaultHourlyRetention is how long hourly usage is kept by default.
+const DefaultHourlyRetention = 7 * 24 * time.Hour
+
+// DefaultDailyRetention is how long daily usage is kept by default.
+const DefaultDailyRetention = 90 * 24 * time.Hour
+
+// ErrNoUsageHistory is returned when querying the usage of a limiter that was
+// not created with WithUsageHistory.
+var ErrNoUsageHistory = errors.New("speedbump: limiter has no usage history")
+
+// refundUsageScript subtracts refunded attempts from usage buckets, without
+// going below zero. KEYS has the buckets and ARGV has the number of attempts.
+// Buckets that already expired are not created again.
+var refundUsageScript = redis.NewScript(`
+for i = 1, #KEYS do
+  local count = tonumber(redis.call("GET", KEYS[i]) or 0)
+  local refund = math.min(count, tonumber(ARGV[1]))
+
+  if refund > 0 then
+    redis.call("DECRBY", KEYS[i], refund)
+  end
+end
+
+return 0
+`)
+
+// UsageHistory configures the long-term usage history of a limiter, which
+// keeps the number of attempts counted for every id in hourly and daily
+// buckets, long after their counters expire. See WithUsageHistory.
+type UsageHistory struct {
+	// HourlyRetention is how long hourly buckets are kept after they end. It
+	// defaults to DefaultHourlyRetention.
+	HourlyRetention time.Duration
+	// DailyRetention is how long daily buckets are kept after they end. It
+	// defaults to DefaultDailyRetention.
+	DailyRetention time.Duration
+	// Location determines when days start. It defaults to UTC.
+	Location *time.Location
+	// Clock is the time reference used to pick buckets. If it is not provided,
+	// the default time is used. This can be replaced with a mock clock object
+	// for testing.
+	Clock clock.Clock
+}
+
+// UsageBucket is the number of attempts counted for an id during an hour or
+// a day.
+type UsageBucket struct {
+	// Start is when the bucket starts.
+	Start time.Time `json:"start"`
+	// Count is the number of attempts counted during the bucket, minus the
+	// ones that were refunded.
+	Count int64 `json:"count"`
+}
+
+// WithUsageHistory keeps the number of attempts counted for every id in hourly
+// and daily buckets, which can be queried with Usage and HourlyUsage. Only
+// attempts that were counted are recorded, so denied attempts are not part of
+// the usage.
+//
+// Recording usage takes an additional call to the Redis server for every
+// attempt that is counted, or batch of attempts. Errors while recording are
+// reported to the metrics of the limiter, but they don't change decisions.
+func WithUsageHistory(history UsageHistory) Option {
+	if history.HourlyRetention <= 0 {
+		history.HourlyRetention = DefaultHourlyRetention
+	}
+
+	if history.DailyRetention <= 0 {
+		history.DailyRetention = DefaultDailyRetention
+	}
+
+	if history.Location == nil {
+		history.Location = time.UTC
+	}
+
+	if history.Clock == nil {
+		history.Clock = clock.New()
+	}
+
+	return func(r *RateLimiter) {
+		r.usage = &history
+	}
+}
+
+// Usage returns the number of attempts counted for an id every day between
+// from and to, including the day from is in. Days without attempts, or that
+// are older than the retention, have a count of zero.
+func (r *RateLimiter) Usage(id string, from, to time.Time) ([]UsageBucket, error) {
+	if r.usage == nil {
+		return nil, ErrNoUsageHistory
+	}
+
+	return r.queryUsage(id, r.usage.day(from), to, r.usage.nextDay, r.dayKey)
+}
+
+// HourlyUsage is like Usage, but it returns the number of attempts counted
+// every hour.
+func (r *RateLimiter) HourlyUsage(id string, from, to time.Time) ([]UsageBucket, error) {
+	if r.usage == nil {
+		return nil, ErrNoUsageHistory
+	}
+
+	return r.queryUsage(id, r.usage.hour(from), to, r.usage.nextHour, r.hourKey)
+}
+
+// queryUsage reads the buckets of an id from start until to.
+func (r *RateLimiter) queryUsage(
+	id string,
+	first, to time.Time,
+	next func(time.Time) time.Time,
+	key func(id string, t time.Time) string,
+) ([]UsageBucket, error) {
+	buckets := []UsageBucket{}
+	keys := []string{}
+
+	for t := first; t.Before(to); t = next(t) {
+		buckets = append(buckets, UsageBucket{Start: t})
+		keys = append(keys, key(id, t))
+	}
+
+	if len(keys) == 0 {
+		return buckets, nil
+	}
+
+	start := time.Now()
+	values, err := r.redisClient.MGet(keys...).Result()
+	r.observeLatency("usage", start)
+
+	if err != nil {
+		return nil, err
+	}
+
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+
+		count, err := strconv.ParseInt(value.(string), 10, 64)
+		if err != nil {
+			return nil, err
+		}
+
+		buckets[i].Count = count
+	}
+
+	return buckets, nil
+}
+
+// usageEntry is a number of attempts counted for an id.
+type usageEntry struct {
+	id   string
+	cost int64
+}
+
+// recordDecisions adds the attempts counted by decisions to the usage
+// history, and records when they were counted so that refunds are subtracted
+// from the same buckets.
+func (r *RateLimiter) recordDecisions(decisions ...Decision) {
+	if r.usage == nil {
+		return
+	}
+
+	entries := []usageEntry{}
+	for _, decision := range decisions {
+		if decision.receipt != nil {
+			entries = append(entries, usageEntry{id: decision.receipt.id, cost: decision.receipt.cost})
+		}
+	}
+
+	at := r.recordUsage(entries...)
+
+	for _, decision := range decisions {
+		if decision.receipt != nil {
+			decision.receipt.at = at
+		}
+	}
+}
+
+// recordUsage adds attempts that were counted to the usage history, if the
+// limiter has one. It returns the time of the buckets they were recorded in.
+func (r *RateLimiter) recordUsage(entries ...usageEntry) time.Time {
+	if r.usage == nil || len(entries) == 0 {
+		return time.Time{}
+	}
+
+	now := r.usage.Clock.Now()
+	hour, day := r.usage.hour(now), r.usage.day(now)
+
+	start := time.Now()
+	_, err := r.redisClient.Pipelined(func(pipe *redis.Pipeline) error {
+		for _, entry := range entries {
+			hourKey, dayKey := r.hourKey(entry.id, hour), r.dayKey(entry.id, day)
+
+			pipe.IncrBy(hourKey, entry.cost)
+			pipe.ExpireAt(hourKey, r.usage.nextHour(hour).Add(r.usage.HourlyRetention))
+			pipe.IncrBy(dayKey, entry.cost)
+			pipe.ExpireAt(dayKey, r.usage.nextDay(day).Add(r.usage.DailyRetention))
+		}
+
+		return nil
+	})
+	r.observeLatency("usage", start)
+
+	if err != nil {
+		r.observeError(err)
+	}
+
+	return now
+}
+
+// refundUsage subtracts refunded attempts from the buckets they were recorded
+// in.
+func (r *RateLimiter) refundUsage(id string, cost int64, at time.Time) error {
+	if r.usage == nil || at.IsZero() {
+		return nil
+	}
+
+	keys := []string{r.hourKey(id, r.usage.hour(at)), r.dayKey(id, r.usage.day(at))}
+
+	start := time.Now()
+	err := refundUsageScript.Run(r.redisClient, keys, cost).Err()
+	r.observeLatency("usage", start)
+
+	return err
+}
+
+// hourKey generates the key of the hourly bucket of an id that starts at t.
+func (r *RateLimiter) hourKey(id string, t time.Time) string {
+	return r.usageKey(id) + ":h:" + t.UTC().Format("2006010215")
+}
+
+// dayKey generates the key of the daily bucket of an id that starts at t.
+func (r *RateLimiter) dayKey(id string, t time.Time) string {
+	return r.usageKey(id) + ":d:" + t.Format("20060102")
+}
+
+// usageKey generates the prefix of the usage buckets of an id.
+func (r *RateLimiter) usageKey(id string) string {
+	if r.namespace == "" {
+		return "usage:" + id
+	}
+
+	return r.namespace + ":usage:" + id
+}
+
+// hour returns the start of the hour t is in.
+func (h *UsageHistory) hour(t time.Time) time.Time {
+	return t.In(h.Location).Truncate(time.Hour)
+}
+
+// nextHour returns the start of the hour after the one that starts at t.
+func (h *UsageHistory) nextHour(t time.Time) time.Time {
+	return t.Add(time.Hour)
+}
+
+// day returns the start of the day t is in.
+func (h *UsageHistory) day(t time.Time) time.Time {
+	t = t.In(h.Location)
+
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, h.Location)
+}
+
+// nextDay returns the start of the day after the one that starts at t, which
+// is not always 24 hours later because of daylight saving time.
+func (h *UsageHistory) nextDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, h.Location)
+}
@@ -0,0 +1,115 @@
+package speedbump
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/facebookgo/clock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// usageCounts returns the counts of usage buckets.
+func usageCounts(buckets []UsageBucket) []int64 {
+	counts := make([]int64, len(buckets))
+	for i, bucket := range buckets {
+		counts[i] = bucket.Count
+	}
+
+	return counts
+}
+
+func TestUsage(t *testing.T) {
+	// Create Redis client and defer DB teardown.
+	client := createClient()
+	defer teardown(t, client)
+
+	// The mock clock starts at midnight two days ago, so that buckets are
+	// within their retention.
+	day := time.Now().UTC().Truncate(24 * time.Hour).Add(-48 * time.Hour)
+	mock := clock.NewMock()
+	mock.Add(day.Sub(mock.Now()))
+
+	limiter := NewLimiter(
+		client, PerMinuteHasher{Clock: mock}, 6,
+		WithNamespace("api"),
+		WithUsageHistory(UsageHistory{Clock: mock}),
+	)
+
+	ctx := context.Background()
+
+	mock.Add(time.Hour + 10*time.Minute)
+	makeNAttempts(t, limiter, "test_id", 3)
+
+	mock.Add(4 * time.Hour)
+	makeNAttempts(t, limiter, "test_id", 1)
+
+	refunded, err := limiter.Decide(ctx, "test_id")
+	require.NoError(t, err)
+
+	// Attempts of other ids are kept separately.
+	makeNAttempts(t, limiter, "other_id", 2)
+
+	mock.Add(19 * time.Hour)
+	makeNAttempts(t, limiter, "test_id", 1)
+
+	_, err = limiter.AttemptMany(ctx, []string{"test_id"}, []int64{4})
+	require.NoError(t, err)
+
+	// Denied attempts are not part of the usage.
+	decisions, err := limiter.AttemptMany(ctx, []string{"test_id"}, []int64{4})
+	require.NoError(t, err)
+	assert.False(t, decisions[0].Allowed)
+
+	buckets, err := limiter.HourlyUsage("test_id", day, day.Add(6*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, buckets, 6)
+	assert.Equal(t, day.Add(time.Hour), buckets[1].Start)
+	assert.Equal(t, []int64{0, 3, 0, 0, 0, 2}, usageCounts(buckets))
+
+	buckets, err = limiter.Usage("test_id", day.Add(12*time.Hour), day.Add(25*time.Hour))
+	require.NoError(t, err)
+	require.Len(t, buckets, 2)
+	assert.Equal(t, day, buckets[0].Start)
+	assert.Equal(t, []int64{5, 5}, usageCounts(buckets))
+
+	// Refunds are subtracted from the buckets of the attempt.
+	require.NoError(t, limiter.Refund(ctx, refunded))
+
+	buckets, err = limiter.Usage("test_id", day, day.Add(48*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{4, 5}, usageCounts(buckets))
+
+	buckets, err = limiter.Usage("other_id", day, day.Add(24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []int64{2}, usageCounts(buckets))
+
+	// Buckets expire once their retention is over.
+	ttl, err := client.TTL(limiter.dayKey("test_id", day)).Result()
+	require.NoError(t, err)
+	assert.InDelta(t, time.Until(day.Add(24*time.Hour+DefaultDailyRetention)), ttl, float64(time.Minute))
+
+	buckets, err = limiter.Usage("test_id", day, day)
+	require.NoError(t, err)
+	assert.Empty(t, buckets)
+}
+
+func TestUsageDisabled(t *testing.T) {
+	limiter := NewLimiter(createBrokenClient(), PerMinuteHasher{}, 1)
+
+	_, err := limiter.Usage("test_id", time.Now().Add(-time.Hour), time.Now())
+	assert.Equal(t, ErrNoUsageHistory, err)
+
+	_, err = limiter.HourlyUsage("test_id", time.Now().Add(-time.Hour), time.Now())
+	assert.Equal(t, ErrNoUsageHistory, err)
+}
+
+func TestUsageLocation(t *testing.T) {
+	location := time.FixedZone("UTC-5", -5*60*60)
+	history := UsageHistory{Location: location}
+
+	start := history.day(time.Date(2024, 3, 10, 3, 0, 0, 0, time.UTC))
+	assert.Equal(t, time.Date(2024, 3, 9, 0, 0, 0, 0, location), start)
+	assert.Equal(t, time.Date(2024, 3, 10, 0, 0, 0, 0, location), history.nextDay(start))
+}